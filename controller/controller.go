@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -56,6 +57,14 @@ var (
 			Help:      "Timestamp of last successful sync with the DNS provider",
 		},
 	)
+	rejectedRecords = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "dops",
+			Subsystem: "controller",
+			Name:      "rejected_desired_records",
+			Help:      "Number of desired records rejected by the planner in the last reconcile loop because they conflict with other records.",
+		},
+	)
 	controllerNoChangesTotal = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Namespace: "dops",
@@ -102,6 +111,62 @@ var (
 			Help:      "Number of DNS A-records that exists both in source and registry.",
 		},
 	)
+	registryAAAARecords = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "dops",
+			Subsystem: "registry",
+			Name:      "aaaa_records",
+			Help:      "Number of Registry AAAA records.",
+		},
+	)
+	sourceAAAARecords = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "dops",
+			Subsystem: "source",
+			Name:      "aaaa_records",
+			Help:      "Number of Source AAAA records.",
+		},
+	)
+	verifiedAAAARecords = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "dops",
+			Subsystem: "controller",
+			Name:      "verified_aaaa_records",
+			Help:      "Number of DNS AAAA-records that exists both in source and registry.",
+		},
+	)
+	deletionGuardRefusalsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "dops",
+			Subsystem: "controller",
+			Name:      "deletion_guard_refusals_total",
+			Help:      "Number of plans refused because they exceeded the deletion caps.",
+		},
+	)
+	orphanedOwnershipRecords = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "dops",
+			Subsystem: "registry",
+			Name:      "orphaned_ownership_records",
+			Help:      "Number of ownership records whose record does not exist.",
+		},
+	)
+	danglingRecords = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "dops",
+			Subsystem: "registry",
+			Name:      "dangling_records",
+			Help:      "Number of owned records which lost their ownership record.",
+		},
+	)
+	orphanedOwnershipDeletionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "dops",
+			Subsystem: "registry",
+			Name:      "orphaned_ownership_records_deleted_total",
+			Help:      "Number of orphaned ownership records deleted by the cleanup.",
+		},
+	)
 )
 
 func init() {
@@ -116,6 +181,14 @@ func init() {
 	prometheus.MustRegister(registryARecords)
 	prometheus.MustRegister(sourceARecords)
 	prometheus.MustRegister(verifiedARecords)
+	prometheus.MustRegister(registryAAAARecords)
+	prometheus.MustRegister(sourceAAAARecords)
+	prometheus.MustRegister(verifiedAAAARecords)
+	prometheus.MustRegister(deletionGuardRefusalsTotal)
+	prometheus.MustRegister(rejectedRecords)
+	prometheus.MustRegister(orphanedOwnershipRecords)
+	prometheus.MustRegister(danglingRecords)
+	prometheus.MustRegister(orphanedOwnershipDeletionsTotal)
 }
 
 // Controller orchestrates different components
@@ -141,32 +214,208 @@ type Controller struct {
 	ManagedRecordTypes []string
 	// MinEventSyncInterval is used as window for batching events
 	MinEventSyncInterval time.Duration
+	// DeletionGuard caps the number of deletions per synchronization, if set
+	DeletionGuard *plan.DeletionGuard
+	// ConflictResolver picks the record among several resources claiming the same DNS name
+	ConflictResolver plan.ConflictResolver
+	// Journal records the applied changes, if set
+	Journal *plan.Journal
+	// CleanupOrphans deletes the ownership records owned by this instance whose record does not exist
+	CleanupOrphans bool
 }
 
 // RunOnce runs a single iteration of a reconciliation loop.
 func (c *Controller) RunOnce(ctx context.Context) error {
+	plan, err := c.Plan(ctx)
+	if err != nil {
+		return err
+	}
+	if plan.Refused != nil {
+		deletionGuardRefusalsTotal.Inc()
+		return plan.Refused
+	}
+
+	if a, ok := c.Registry.(registry.OwnershipAuditor); ok {
+		if err := c.auditOwnership(ctx, a, plan); err != nil {
+			registryErrorsTotal.Inc()
+			deprecatedRegistryErrors.Inc()
+			return err
+		}
+	}
+
+	if plan.Changes.HasChanges() {
+		if err := c.applyChanges(ctx, plan.Changes); err != nil {
+			return err
+		}
+	} else {
+		controllerNoChangesTotal.Inc()
+		log.Info("All records are already up to date")
+	}
+
+	if m, ok := c.Registry.(registry.Migrator); ok {
+		if err := m.Migrate(ctx); err != nil {
+			registryErrorsTotal.Inc()
+			deprecatedRegistryErrors.Inc()
+			return err
+		}
+	}
+
+	lastSyncTimestamp.SetToCurrentTime()
+	return nil
+}
+
+// auditOwnership reports the inconsistent ownership found when the records were read and
+// deletes the orphaned ownership records if enabled, before the changes are applied so that
+// the ownership records of created records do not collide with them. The deletions count
+// against the caps of the deletion guard together with the deletions of the plan, and are
+// only made if the policy allows to delete the records the ownership records belonged to.
+func (c *Controller) auditOwnership(ctx context.Context, a registry.OwnershipAuditor, p *plan.Plan) error {
+	audit := a.OwnershipAudit()
+	orphanedOwnershipRecords.Set(float64(len(audit.Orphaned)))
+	danglingRecords.Set(float64(len(audit.Dangling)))
+	if !c.CleanupOrphans || len(audit.Removable) == 0 {
+		return nil
+	}
+	if c.DeletionGuard != nil {
+		deletions := append(append([]*endpoint.Endpoint{}, p.Changes.Delete...), audit.Removable...)
+		if err := c.DeletionGuard.Check(&plan.Changes{Delete: deletions}, p.Current); err != nil {
+			deletionGuardRefusalsTotal.Inc()
+			log.Warnf("Keeping %d orphaned ownership record(s): %v", len(audit.Removable), err)
+			return nil
+		}
+	}
+
+	deleted, err := a.DeleteOrphanedOwnership(ctx, c.Policy)
+	if err != nil {
+		return err
+	}
+	orphanedOwnershipDeletionsTotal.Add(float64(deleted))
+	orphanedOwnershipRecords.Sub(float64(deleted))
+	return nil
+}
+
+// ApplyPlan applies the changes of a saved plan. It returns a plan.StalePlanError without
+// applying any change if the records of the registry changed since the plan was calculated.
+func (c *Controller) ApplyPlan(ctx context.Context, saved *plan.SavedPlan) error {
 	records, err := c.Registry.Records(ctx)
 	if err != nil {
 		registryErrorsTotal.Inc()
 		deprecatedRegistryErrors.Inc()
 		return err
 	}
+	if err := saved.CheckCurrent(records); err != nil {
+		return err
+	}
+
+	if !saved.Changes.HasChanges() {
+		log.Info("Plan has no changes, all records are already up to date")
+		return nil
+	}
+	if err := c.applyChanges(ctx, saved.Changes); err != nil {
+		return err
+	}
+	lastSyncTimestamp.SetToCurrentTime()
+	return nil
+}
+
+// applyChanges logs the changes with their reasons, applies them and records them in the journal
+func (c *Controller) applyChanges(ctx context.Context, changes *plan.Changes) error {
+	for _, change := range plan.NewOutput(changes).Changes {
+		log.WithFields(log.Fields{
+			"action": change.Action,
+			"record": change.DNSName,
+			"type":   change.RecordType,
+			"setID":  change.SetIdentifier,
+			"reason": change.Reason,
+		}).Info("Applying change")
+	}
+
+	if err := c.Registry.ApplyChanges(ctx, changes); err != nil {
+		registryErrorsTotal.Inc()
+		deprecatedRegistryErrors.Inc()
+		return err
+	}
+
+	if c.Journal != nil {
+		cycle, err := c.Journal.Append(changes)
+		if err != nil {
+			log.Errorf("Failed to write the change journal %s: %v", c.Journal.Path, err)
+		} else {
+			log.Infof("Recorded the changes as cycle %d in the change journal", cycle)
+		}
+	}
+	return nil
+}
+
+// Rollback undoes the changes applied after the given cycle of the change journal, newest
+// cycle first. Changes which no longer match the current records or which the policy does not
+// allow are skipped, and the deletion guard applies to each cycle like to a plan.
+func (c *Controller) Rollback(ctx context.Context, to int) error {
+	if c.Journal == nil {
+		return errors.New("rollback requires a change journal")
+	}
+	entries, err := plan.ReadJournal(c.Journal.Path)
+	if err != nil {
+		return err
+	}
+	rollback, err := plan.RollbackChanges(entries, to)
+	if err != nil {
+		return err
+	}
+
+	for _, changes := range rollback {
+		records, err := c.Registry.Records(ctx)
+		if err != nil {
+			registryErrorsTotal.Inc()
+			deprecatedRegistryErrors.Inc()
+			return err
+		}
+		changes = plan.ApplyPolicy(c.Policy, plan.KeepApplicable(changes, records))
+		if !changes.HasChanges() {
+			continue
+		}
+		if c.DeletionGuard != nil {
+			if err := c.DeletionGuard.Check(changes, records); err != nil {
+				deletionGuardRefusalsTotal.Inc()
+				return err
+			}
+		}
+		if err := c.applyChanges(ctx, changes); err != nil {
+			return err
+		}
+	}
+	if len(rollback) == 0 {
+		log.Infof("No changes were applied after cycle %d", to)
+	}
+	lastSyncTimestamp.SetToCurrentTime()
+	return nil
+}
+
+// Plan calculates the changes which move the records of the registry towards
+// the endpoints of the source, without applying them.
+func (c *Controller) Plan(ctx context.Context) (*plan.Plan, error) {
+	records, err := c.Registry.Records(ctx)
+	if err != nil {
+		registryErrorsTotal.Inc()
+		deprecatedRegistryErrors.Inc()
+		return nil, err
+	}
 	registryEndpointsTotal.Set(float64(len(records)))
-	regARecords := filterARecords(records)
-	registryARecords.Set(float64(len(regARecords)))
+	registryARecords.Set(float64(len(filterRecords(records, endpoint.RecordTypeA))))
+	registryAAAARecords.Set(float64(len(filterRecords(records, endpoint.RecordTypeAAAA))))
 	ctx = context.WithValue(ctx, provider.RecordsContextKey, records)
 
 	endpoints, err := c.Source.Endpoints(ctx)
 	if err != nil {
 		sourceErrorsTotal.Inc()
 		deprecatedSourceErrors.Inc()
-		return err
+		return nil, err
 	}
 	sourceEndpointsTotal.Set(float64(len(endpoints)))
-	srcARecords := filterARecords(endpoints)
-	sourceARecords.Set(float64(len(srcARecords)))
-	vRecords := fetchMatchingARecords(endpoints, records)
-	verifiedARecords.Set(float64(len(vRecords)))
+	sourceARecords.Set(float64(len(filterRecords(endpoints, endpoint.RecordTypeA))))
+	sourceAAAARecords.Set(float64(len(filterRecords(endpoints, endpoint.RecordTypeAAAA))))
+	verifiedARecords.Set(float64(len(fetchMatchingRecords(endpoints, records, endpoint.RecordTypeA))))
+	verifiedAAAARecords.Set(float64(len(fetchMatchingRecords(endpoints, records, endpoint.RecordTypeAAAA))))
 	endpoints = c.Registry.AdjustEndpoints(endpoints)
 
 	plan := &plan.Plan{
@@ -176,35 +425,24 @@ func (c *Controller) RunOnce(ctx context.Context) error {
 		DomainFilter:       endpoint.MatchAllDomainFilters{c.DomainFilter, c.Registry.GetDomainFilter()},
 		PropertyComparator: c.Registry.PropertyValuesEqual,
 		ManagedRecords:     c.ManagedRecordTypes,
+		DeletionGuard:      c.DeletionGuard,
+		ConflictResolver:   c.ConflictResolver,
 	}
 
-	plan = plan.Calculate()
-
-	if plan.Changes.HasChanges() {
-		err = c.Registry.ApplyChanges(ctx, plan.Changes)
-		if err != nil {
-			registryErrorsTotal.Inc()
-			deprecatedRegistryErrors.Inc()
-			return err
-		}
-	} else {
-		controllerNoChangesTotal.Inc()
-		log.Info("All records are already up to date")
-	}
-
-	lastSyncTimestamp.SetToCurrentTime()
-	return nil
+	calculated := plan.Calculate()
+	rejectedRecords.Set(float64(len(calculated.Rejected)))
+	return calculated, nil
 }
 
-// Checks and returns the intersection of A records in endpoint and registry.
-func fetchMatchingARecords(endpoints []*endpoint.Endpoint, registryRecords []*endpoint.Endpoint) []string {
-	aRecords := filterARecords(endpoints)
+// Checks and returns the intersection of records of the given type in endpoint and registry.
+func fetchMatchingRecords(endpoints []*endpoint.Endpoint, registryRecords []*endpoint.Endpoint, recordType string) []string {
+	records := filterRecords(endpoints, recordType)
 	recordsMap := make(map[string]struct{})
-	for _, regRecord := range registryRecords {
-		recordsMap[regRecord.DNSName] = struct{}{}
+	for _, regRecord := range filterRecords(registryRecords, recordType) {
+		recordsMap[regRecord] = struct{}{}
 	}
 	var cm []string
-	for _, sourceRecord := range aRecords {
+	for _, sourceRecord := range records {
 		if _, found := recordsMap[sourceRecord]; found {
 			cm = append(cm, sourceRecord)
 		}
@@ -212,14 +450,14 @@ func fetchMatchingARecords(endpoints []*endpoint.Endpoint, registryRecords []*en
 	return cm
 }
 
-func filterARecords(endpoints []*endpoint.Endpoint) []string {
-	var aRecords []string
+func filterRecords(endpoints []*endpoint.Endpoint, recordType string) []string {
+	var records []string
 	for _, endPoint := range endpoints {
-		if endPoint.RecordType == endpoint.RecordTypeA {
-			aRecords = append(aRecords, endPoint.DNSName)
+		if endPoint.RecordType == recordType {
+			records = append(records, endPoint.DNSName)
 		}
 	}
-	return aRecords
+	return records
 }
 
 // ScheduleRunOnce makes sure execution happens at most once per interval.
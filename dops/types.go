@@ -22,8 +22,39 @@ var (
 	Version = "unknown"
 )
 
+// Commands of the dops binary
+const (
+	// CommandController continuously synchronizes the DNS records, it is the default command
+	CommandController = "controller"
+	// CommandPlan prints the changes a single synchronization would make
+	CommandPlan = "plan"
+	// CommandApply applies the changes of a plan saved by the plan command
+	CommandApply = "apply"
+	// CommandRollback undoes the changes recorded in the change journal after a cycle
+	CommandRollback = "rollback"
+	// CommandExport writes the records of the provider to a snapshot
+	CommandExport = "export"
+	// CommandImport restores the records of a snapshot written by the export command
+	CommandImport = "import"
+	// CommandOwnershipList prints the managed names with their owners
+	CommandOwnershipList = "ownership list"
+	// CommandOwnershipTransfer hands the records of one owner id over to another
+	CommandOwnershipTransfer = "ownership transfer"
+)
+
 // Config is project-wide configuration
 type Config struct {
+	Command                 string
+	PlanOutput              string
+	PlanFile                string
+	RollbackTo              int
+	SnapshotFile            string
+	SnapshotFormat          string
+	ExportOwnedOnly         bool
+	OwnershipOutput         string
+	OwnershipFrom           string
+	OwnershipTo             string
+	OwnershipDomains        []string
 	DefaultTargets          []string
 	Sources                 []string
 	FQDNTemplate            string
@@ -46,8 +77,26 @@ type Config struct {
 	AWSZoneCacheDuration    time.Duration
 	CloudflareProxied       bool
 	CloudflareZonesPerPage  int
+	CloudflareRateLimit     float64
+	CloudflareAPIRetries    int
+	CloudflareZoneWorkers   int
+	CloudflareAccountIDs    []string
+	CloudflareCredentials   string
 	InMemoryZones           []string
+	InMemorySnapshotFile    string
+	InMemoryFailEveryNth    int
+	InMemoryFailZones       []string
+	InMemoryThrottleEvery   int
+	InMemoryLatency         time.Duration
+	InMemoryRecordsLag      int
 	Policy                  string
+	DomainPolicies          []string
+	ProtectedNames          []string
+	ConflictResolver        string
+	ChangeJournal           string
+	MaxDeletions            int
+	MaxDeletionsPercent     float64
+	AllowMassDeletion       bool
 	Registry                string
 	TXTOwnerID              string
 	TXTPrefix               string
@@ -62,6 +111,12 @@ type Config struct {
 	LogLevel                string
 	TXTCacheInterval        time.Duration
 	TXTWildcardReplacement  string
+	TXTFormat               string
+	TXTEncryptionKeys       []string `secure:"yes"`
+	TXTEncryptionKeyFile    string
+	TXTCleanupOrphans       bool
+	RegistryStateFile       string
+	RegistryImportTXT       bool
 	ManagedDNSRecordTypes   []string
 }
 
@@ -88,14 +143,38 @@ var defaultConfig = &Config{
 	AWSZoneCacheDuration:    0 * time.Second,
 	CloudflareProxied:       false,
 	CloudflareZonesPerPage:  50,
+	CloudflareRateLimit:     4,
+	CloudflareAPIRetries:    3,
+	CloudflareZoneWorkers:   4,
+	CloudflareAccountIDs:    []string{},
+	CloudflareCredentials:   "",
 	InMemoryZones:           []string{},
+	InMemorySnapshotFile:    "",
+	InMemoryFailEveryNth:    0,
+	InMemoryFailZones:       []string{},
+	InMemoryThrottleEvery:   0,
+	InMemoryLatency:         0,
+	InMemoryRecordsLag:      0,
 	Policy:                  "sync",
+	DomainPolicies:          []string{},
+	ProtectedNames:          []string{},
+	ConflictResolver:        "per-resource",
+	ChangeJournal:           "",
+	MaxDeletions:            0,
+	MaxDeletionsPercent:     0,
+	AllowMassDeletion:       false,
 	Registry:                "txt",
 	TXTOwnerID:              "default",
 	TXTPrefix:               "",
 	TXTSuffix:               "",
 	TXTCacheInterval:        0,
 	TXTWildcardReplacement:  "",
+	TXTFormat:               "legacy",
+	TXTEncryptionKeys:       []string{},
+	TXTEncryptionKeyFile:    "",
+	TXTCleanupOrphans:       false,
+	RegistryStateFile:       "",
+	RegistryImportTXT:       false,
 	MinEventSyncInterval:    5 * time.Second,
 	Interval:                time.Minute,
 	Once:                    false,
@@ -104,6 +183,17 @@ var defaultConfig = &Config{
 	LogFormat:               "text",
 	MetricsAddress:          ":7979",
 	LogLevel:                logrus.InfoLevel.String(),
+	Command:                 CommandController,
+	PlanOutput:              "table",
+	PlanFile:                "",
+	RollbackTo:              0,
+	SnapshotFile:            "",
+	SnapshotFormat:          "json",
+	ExportOwnedOnly:         false,
+	OwnershipOutput:         "table",
+	OwnershipFrom:           "",
+	OwnershipTo:             "",
+	OwnershipDomains:        []string{},
 	ManagedDNSRecordTypes:   []string{endpoint.RecordTypeA, endpoint.RecordTypeCNAME},
 }
 
@@ -120,12 +210,21 @@ func (cfg *Config) String() string {
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
 		if val, ok := f.Tag.Lookup("secure"); ok && val == "yes" {
-			if f.Type.Kind() != reflect.String {
-				continue
-			}
 			v := reflect.ValueOf(&temp).Elem().Field(i)
-			if v.String() != "" {
-				v.SetString(passwordMask)
+			switch f.Type.Kind() {
+			case reflect.String:
+				if v.String() != "" {
+					v.SetString(passwordMask)
+				}
+			case reflect.Slice:
+				if f.Type.Elem().Kind() != reflect.String || v.Len() == 0 {
+					continue
+				}
+				masked := make([]string, v.Len())
+				for j := range masked {
+					masked[j] = passwordMask
+				}
+				v.Set(reflect.ValueOf(masked))
 			}
 		}
 	}
@@ -151,7 +250,7 @@ func (cfg *Config) ParseFlags(args []string) error {
 	// Sources
 	boot.Flag("source", "The resource types that are queried for endpoints; specify multiple times for multiple sources (required, options: dummy, connector, empty)").Required().PlaceHolder("source").EnumsVar(&cfg.Sources, "dummy", "connector", "empty")
 	boot.Flag("fqdn-template", "A templated string that's used to generate DNS names from sources that don't define a hostname themselves, or to add a hostname suffix when paired with the dummy source (optional)").Default(defaultConfig.FQDNTemplate).StringVar(&cfg.FQDNTemplate)
-	boot.Flag("managed-record-types", "Comma separated list of record types to manage (default: A, CNAME) (supported records: CNAME, A, NS").Default("A", "CNAME").StringsVar(&cfg.ManagedDNSRecordTypes)
+	boot.Flag("managed-record-types", "Comma separated list of record types to manage (default: A, CNAME) (supported records: CNAME, A, AAAA, NS, MX, SRV, CAA, TXT)").Default("A", "CNAME").StringsVar(&cfg.ManagedDNSRecordTypes)
 	boot.Flag("default-targets", "Set globally default IP address that will apply as a target instead of source addresses. Specify multiple times for multiple targets (optional)").StringsVar(&cfg.DefaultTargets)
 	boot.Flag("connector-source-server", "The server to connect for connector source, valid only when using connector source").Default(defaultConfig.ConnectorSourceServer).StringVar(&cfg.ConnectorSourceServer)
 	boot.Flag("publish-host-ip", "Allow dops to publish host-ip for headless services (optional)").BoolVar(&cfg.PublishHostIP)
@@ -176,18 +275,43 @@ func (cfg *Config) ParseFlags(args []string) error {
 
 	boot.Flag("cloudflare-proxied", "When using the Cloudflare provider, specify if the proxy mode must be enabled (default: disabled)").BoolVar(&cfg.CloudflareProxied)
 	boot.Flag("cloudflare-zones-per-page", "When using the Cloudflare provider, specify how many zones per page listed, max. possible 50 (default: 50)").Default(strconv.Itoa(defaultConfig.CloudflareZonesPerPage)).IntVar(&cfg.CloudflareZonesPerPage)
+	boot.Flag("cloudflare-requests-per-second", "When using the Cloudflare provider, set the maximum rate of API calls; the API allows 1200 calls per 5 minutes (default: 4)").Default(strconv.FormatFloat(defaultConfig.CloudflareRateLimit, 'f', -1, 64)).Float64Var(&cfg.CloudflareRateLimit)
+	boot.Flag("cloudflare-api-retries", "When using the Cloudflare provider, set the maximum number of retries for throttled or failed API calls before giving up.").Default(strconv.Itoa(defaultConfig.CloudflareAPIRetries)).IntVar(&cfg.CloudflareAPIRetries)
+	boot.Flag("cloudflare-zone-concurrency", "When using the Cloudflare provider, set the number of zones read or changed in parallel (default: 4)").Default(strconv.Itoa(defaultConfig.CloudflareZoneWorkers)).IntVar(&cfg.CloudflareZoneWorkers)
+	boot.Flag("cloudflare-account-id", "When using the Cloudflare provider, only manage zones of this account; specify multiple times for multiple accounts (optional)").Default("").StringsVar(&cfg.CloudflareAccountIDs)
+	boot.Flag("cloudflare-credentials-file", "When using the Cloudflare provider, read the credentials of accounts and zones from this YAML file; the CF_API_* environment variables are used for the others (optional)").Default(defaultConfig.CloudflareCredentials).StringVar(&cfg.CloudflareCredentials)
 
 	boot.Flag("inmemory-zone", "Provide a list of pre-configured zones for the inmemory provider; specify multiple times for multiple zones (optional)").Default("").StringsVar(&cfg.InMemoryZones)
+	boot.Flag("inmemory-fail-every-nth-apply", "When using the inmemory provider, fail every nth application of changes (optional, 0 to disable)").Default(strconv.Itoa(defaultConfig.InMemoryFailEveryNth)).IntVar(&cfg.InMemoryFailEveryNth)
+	boot.Flag("inmemory-fail-zone", "When using the inmemory provider, fail applying changes to this zone; specify multiple times for multiple zones (optional)").Default("").StringsVar(&cfg.InMemoryFailZones)
+	boot.Flag("inmemory-throttle-every-nth-call", "When using the inmemory provider, return a throttling error on every nth provider call (optional, 0 to disable)").Default(strconv.Itoa(defaultConfig.InMemoryThrottleEvery)).IntVar(&cfg.InMemoryThrottleEvery)
+	boot.Flag("inmemory-latency", "When using the inmemory provider, add this latency to every provider call (optional)").Default(defaultConfig.InMemoryLatency.String()).DurationVar(&cfg.InMemoryLatency)
+	boot.Flag("inmemory-records-lag", "When using the inmemory provider, number of change applications the listed records lag behind to simulate eventual consistency (optional, 0 to disable)").Default(strconv.Itoa(defaultConfig.InMemoryRecordsLag)).IntVar(&cfg.InMemoryRecordsLag)
+	boot.Flag("inmemory-snapshot-file", "When using the inmemory provider, persist zones and records as JSON to this file after every change and restore them from it on start (optional)").Default(defaultConfig.InMemorySnapshotFile).StringVar(&cfg.InMemorySnapshotFile)
 
 	// Policies
 	boot.Flag("policy", "Modify how DNS records are synchronized between sources and providers (default: sync, options: sync, upsert-only, create-only)").Default(defaultConfig.Policy).EnumVar(&cfg.Policy, "sync", "upsert-only", "create-only")
+	boot.Flag("domain-policy", "Override the policy for the records of a zone or domain suffix, as <domain>=<policy>; specify multiple times for multiple domains, the most specific domain wins (optional)").StringsVar(&cfg.DomainPolicies)
+	boot.Flag("protected-name", "Never update or delete records matching this glob, e.g. *.pay.example.com, or regular expression prefixed with regex:; specify multiple times for multiple patterns (optional)").StringsVar(&cfg.ProtectedNames)
+	boot.Flag("conflict-resolver", "How to choose between resources claiming the same DNS name (default: per-resource, options: per-resource, priority, merge-targets, oldest-claim); priority and oldest-claim read the priority and claimed-at labels of the endpoints").Default(defaultConfig.ConflictResolver).EnumVar(&cfg.ConflictResolver, "per-resource", "priority", "merge-targets", "oldest-claim")
+	boot.Flag("change-journal", "When set, appends the changes applied in each cycle, with the reasons they were planned, as a JSON line to this file; required by the rollback command (default: disabled)").Default(defaultConfig.ChangeJournal).StringVar(&cfg.ChangeJournal)
+	boot.Flag("max-deletions", "Refuse all changes of a synchronization which deletes more than this number of records (optional, 0 to disable)").Default(strconv.Itoa(defaultConfig.MaxDeletions)).IntVar(&cfg.MaxDeletions)
+	boot.Flag("max-deletions-percent", "Refuse all changes of a synchronization which deletes more than this percentage of the owned records (optional, 0 to disable)").Default(strconv.FormatFloat(defaultConfig.MaxDeletionsPercent, 'f', -1, 64)).Float64Var(&cfg.MaxDeletionsPercent)
+	boot.Flag("allow-mass-deletion", "Apply changes exceeding max-deletions or max-deletions-percent, for intentional bulk cleanups (default: disabled)").BoolVar(&cfg.AllowMassDeletion)
 
 	// Registry
-	boot.Flag("registry", "The registry implementation to use to keep track of DNS record ownership (default: txt, options: txt, noop)").Default(defaultConfig.Registry).EnumVar(&cfg.Registry, "txt", "noop")
-	boot.Flag("txt-owner-id", "When using the TXT registry, a name that identifies this instance of DNSOps (default: default)").Default(defaultConfig.TXTOwnerID).StringVar(&cfg.TXTOwnerID)
+	boot.Flag("registry", "The registry implementation to use to keep track of DNS record ownership (default: txt, options: txt, file, noop)").Default(defaultConfig.Registry).EnumVar(&cfg.Registry, "txt", "file", "noop")
+	boot.Flag("txt-owner-id", "When using the TXT or file registry, a name that identifies this instance of DNSOps (default: default)").Default(defaultConfig.TXTOwnerID).StringVar(&cfg.TXTOwnerID)
 	boot.Flag("txt-prefix", "When using the TXT registry, a custom string that's prefixed to each ownership DNS record (optional). Mutually exclusive with txt-suffix.").Default(defaultConfig.TXTPrefix).StringVar(&cfg.TXTPrefix)
 	boot.Flag("txt-suffix", "When using the TXT registry, a custom string that's suffixed to the host portion of each ownership DNS record (optional). Mutually exclusive with txt-prefix.").Default(defaultConfig.TXTSuffix).StringVar(&cfg.TXTSuffix)
 	boot.Flag("txt-wildcard-replacement", "When using the TXT registry, a custom string that's used instead of an asterisk for TXT records corresponding to wildcard DNS records (optional)").Default(defaultConfig.TXTWildcardReplacement).StringVar(&cfg.TXTWildcardReplacement)
+	boot.Flag("txt-format", "When using the TXT registry, the naming format of the ownership records; typed puts the record type in front of the txt-prefix or behind the txt-suffix, e.g. a-txt-host and cname-txt-host, and migrate converts the legacy ownership records to it; both require txt-prefix or txt-suffix (default: legacy, options: legacy, typed, migrate)").Default(defaultConfig.TXTFormat).EnumVar(&cfg.TXTFormat, "legacy", "typed", "migrate")
+	boot.Flag("txt-encryption-key", "When using the TXT registry, a base64 encoded AES key of 16, 24 or 32 bytes to encrypt the ownership records with; specify multiple times to rotate keys, the first encrypts and all decrypt, unencrypted records stay readable (optional)").StringsVar(&cfg.TXTEncryptionKeys)
+	boot.Flag("txt-encryption-key-file", "When using the TXT registry, a file holding base64 encoded encryption keys, one per line, appended to the keys given with --txt-encryption-key (optional)").Default(defaultConfig.TXTEncryptionKeyFile).StringVar(&cfg.TXTEncryptionKeyFile)
+
+	boot.Flag("txt-cleanup-orphans", "When using the TXT registry, delete the ownership records owned by this instance whose record no longer exists, e.g. after a manual deletion, within the deletion caps; orphaned and dangling records are reported in the logs and metrics either way (default: disabled)").BoolVar(&cfg.TXTCleanupOrphans)
+	boot.Flag("registry-state-file", "When using the file registry, the JSON file holding the owned records; written atomically after every change (required with --registry=file)").Default(defaultConfig.RegistryStateFile).StringVar(&cfg.RegistryStateFile)
+	boot.Flag("registry-import-txt", "When using the file registry and the state file does not exist yet, import the ownership of the records from the TXT registry, configured with the txt-* flags (default: disabled)").BoolVar(&cfg.RegistryImportTXT)
 
 	// Control loop
 	boot.Flag("txt-cache-interval", "The interval between cache synchronizations in duration format (default: disabled)").Default(defaultConfig.TXTCacheInterval.String()).DurationVar(&cfg.TXTCacheInterval)
@@ -202,10 +326,34 @@ func (cfg *Config) ParseFlags(args []string) error {
 	boot.Flag("log-format", "The format in which log messages are printed (default: text, options: text, json)").Default(defaultConfig.LogFormat).EnumVar(&cfg.LogFormat, "text", "json")
 	boot.Flag("log-level", "Set the level of logging. (default: info, options: panic, debug, info, warning, error, fatal").Default(defaultConfig.LogLevel).EnumVar(&cfg.LogLevel, allLogLevelsAsStrings()...)
 
-	_, err := boot.Parse(args)
+	// Commands
+	boot.Command(CommandController, "Synchronize the DNS records continuously, or once with --once (default)").Default()
+	planCmd := boot.Command(CommandPlan, "Print the changes a single synchronization would make and exit, with exit code 2 if there are changes")
+	planCmd.Flag("output", "The format of the printed changes (default: table, options: table, json)").Short('o').Default(defaultConfig.PlanOutput).EnumVar(&cfg.PlanOutput, "table", "json")
+	planCmd.Flag("plan-file", "Save the changes and the records they were calculated against to this file, to be applied with the apply command (optional)").Default(defaultConfig.PlanFile).StringVar(&cfg.PlanFile)
+	applyCmd := boot.Command(CommandApply, "Apply the changes of a plan saved by the plan command, unless the records changed since it was calculated")
+	applyCmd.Flag("plan-file", "The plan file written by the plan command (required)").Required().StringVar(&cfg.PlanFile)
+	rollbackCmd := boot.Command(CommandRollback, "Undo the changes recorded in the change journal after the given cycle; stop the controllers and revert the sources first, or they apply the changes again")
+	rollbackCmd.Flag("to", "The cycle of the change journal to roll back to (required)").Required().IntVar(&cfg.RollbackTo)
+	exportCmd := boot.Command(CommandExport, "Write the records of the provider to a snapshot and exit")
+	exportCmd.Flag("output", "The format of the snapshot (default: json, options: json, bind); only json snapshots can be imported").Short('o').Default(defaultConfig.SnapshotFormat).EnumVar(&cfg.SnapshotFormat, "json", "bind")
+	exportCmd.Flag("file", "Write the snapshot to this file instead of stdout (optional)").Default(defaultConfig.SnapshotFile).StringVar(&cfg.SnapshotFile)
+	exportCmd.Flag("owned-only", "Only export the records owned by this instance, requires the txt or file registry (default: disabled)").BoolVar(&cfg.ExportOwnedOnly)
+	ownershipCmd := boot.Command("ownership", "Inspect and hand over the ownership of records")
+	ownershipListCmd := ownershipCmd.Command("list", "Print every managed name with its owner, resource and record types")
+	ownershipListCmd.Flag("output", "The format of the list (default: table, options: table, json)").Short('o').Default(defaultConfig.OwnershipOutput).EnumVar(&cfg.OwnershipOutput, "table", "json")
+	ownershipTransferCmd := ownershipCmd.Command("transfer", "Rewrite the ownership records of the records owned by one owner id to another, e.g. when renaming or splitting an instance; requires the txt registry")
+	ownershipTransferCmd.Flag("from", "The owner id to take the records from (required)").Required().StringVar(&cfg.OwnershipFrom)
+	ownershipTransferCmd.Flag("to", "The owner id to hand the records to (required)").Required().StringVar(&cfg.OwnershipTo)
+	ownershipTransferCmd.Flag("domain", "Only transfer the records within this domain; specify multiple times for multiple domains (optional)").StringsVar(&cfg.OwnershipDomains)
+	importCmd := boot.Command(CommandImport, "Synchronize the records once with the records of a json snapshot instead of the sources; the records are planned like any other, so their types must be managed record types")
+	importCmd.Flag("file", "The snapshot written by the export command (required)").Required().StringVar(&cfg.SnapshotFile)
+
+	command, err := boot.Parse(args)
 	if err != nil {
 		return err
 	}
+	cfg.Command = command
 
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/toppr-systems/dops/dops"
+	"github.com/toppr-systems/dops/endpoint"
 )
 
 // ValidateConfig performs validation on the Config object
@@ -24,5 +25,24 @@ func ValidateConfig(cfg *dops.Config) error {
 		return errors.New("txt-prefix and txt-suffix are mutually exclusive")
 	}
 
+	// in the legacy naming format and without an affix the ownership record of an A or CNAME record has the same name as the record itself,
+	// so it would end up in the same record set as a managed TXT record of that name
+	if cfg.Registry == "txt" && cfg.TXTFormat == "legacy" && len(cfg.TXTPrefix) == 0 && len(cfg.TXTSuffix) == 0 {
+		for _, t := range cfg.ManagedDNSRecordTypes {
+			if t == endpoint.RecordTypeTXT {
+				return errors.New("managing TXT records with the txt registry requires txt-prefix or txt-suffix")
+			}
+		}
+	}
+
+	// typed ownership names are told apart from legacy ones by the position of the affix
+	if cfg.Registry == "txt" && cfg.TXTFormat != "legacy" && len(cfg.TXTPrefix) == 0 && len(cfg.TXTSuffix) == 0 {
+		return fmt.Errorf("the %s txt format requires txt-prefix or txt-suffix", cfg.TXTFormat)
+	}
+
+	if cfg.Registry == "file" && cfg.RegistryStateFile == "" {
+		return errors.New("the file registry requires registry-state-file")
+	}
+
 	return nil
 }
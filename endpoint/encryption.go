@@ -0,0 +1,133 @@
+package endpoint
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrEncryptedLabels is returned when encrypted labels are parsed without a LabelsCipher
+	ErrEncryptedLabels = errors.New("labels are encrypted")
+	// ErrUndecryptableLabels is returned when none of the keys of a LabelsCipher decrypts the labels
+	ErrUndecryptableLabels = errors.New("labels can not be decrypted with any of the keys")
+)
+
+// encryptedLabelsPrefix starts the serialized form of encrypted labels, the ciphertext follows
+// as unpadded URL-safe base64
+var encryptedLabelsPrefix = fmt.Sprintf("origin=%s,encrypted=", origin)
+
+// LabelsCipher encrypts serialized labels with AES-GCM, so that the ownership records
+// in public zones do not reveal the owners and resources. Labels are encrypted with the
+// first key and decrypted with any of the keys, which allows to rotate keys.
+type LabelsCipher struct {
+	keys []labelsKey
+}
+
+type labelsKey struct {
+	aead cipher.AEAD
+	// nonceKey derives the nonce from the plaintext, so that the same labels always
+	// encrypt to the same ownership record
+	nonceKey []byte
+}
+
+// NewLabelsCipher returns a LabelsCipher for the given AES keys of 16, 24 or 32 bytes
+func NewLabelsCipher(keys [][]byte) (*LabelsCipher, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption key given")
+	}
+	c := &LabelsCipher{}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %d: %v", i+1, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("dops labels nonce"))
+		c.keys = append(c.keys, labelsKey{aead: aead, nonceKey: mac.Sum(nil)})
+	}
+	return c, nil
+}
+
+// ParseLabelsKeys decodes base64 encoded keys, one per line or comma separated
+func ParseLabelsKeys(text string) ([][]byte, error) {
+	keys := [][]byte{}
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			return nil, fmt.Errorf("encryption keys must be base64 encoded: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Serialize transforms the labels into the encrypted dops recognizable format string
+// withQuotes adds additional quotes
+func (c *LabelsCipher) Serialize(l Labels, withQuotes bool) string {
+	plaintext := []byte(l.Serialize(false))
+	key := c.keys[0]
+
+	mac := hmac.New(sha256.New, key.nonceKey)
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:key.aead.NonceSize()]
+
+	sealed := key.aead.Seal(append([]byte{}, nonce...), nonce, plaintext, nil)
+	text := encryptedLabelsPrefix + base64.RawURLEncoding.EncodeToString(sealed)
+	if withQuotes {
+		return fmt.Sprintf("\"%s\"", text)
+	}
+	return text
+}
+
+// NewLabelsFromString constructs endpoint labels from a provided format string, decrypting
+// encrypted labels and accepting unencrypted ones
+func (c *LabelsCipher) NewLabelsFromString(labelText string) (Labels, error) {
+	sealed, ok := encryptedLabels(labelText)
+	if !ok {
+		return NewLabelsFromString(labelText)
+	}
+	for _, key := range c.keys {
+		if len(sealed) < key.aead.NonceSize() {
+			break
+		}
+		nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+		plaintext, err := key.aead.Open(nil, nonce, ciphertext, nil)
+		if err == nil {
+			return NewLabelsFromString(string(plaintext))
+		}
+	}
+	return nil, ErrUndecryptableLabels
+}
+
+// encryptedLabels returns the ciphertext of encrypted labels, and false if the labels are not encrypted
+func encryptedLabels(labelText string) ([]byte, bool) {
+	labelText = strings.Trim(labelText, "\"")
+	if !strings.HasPrefix(labelText, encryptedLabelsPrefix) {
+		return nil, false
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(labelText, encryptedLabelsPrefix))
+	if err != nil {
+		return nil, false
+	}
+	return sealed, true
+}
+
+// IsOwnershipText returns true if the text holds labels, encrypted or not
+func IsOwnershipText(text string) bool {
+	_, err := NewLabelsFromString(text)
+	return err == nil || err == ErrEncryptedLabels
+}
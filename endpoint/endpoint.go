@@ -9,11 +9,14 @@ import (
 const (
 	// RecordType enum values
 	RecordTypeA     = "A"
+	RecordTypeAAAA  = "AAAA"
 	RecordTypeCNAME = "CNAME"
 	RecordTypeTXT   = "TXT"
 	RecordTypeSRV   = "SRV"
 	RecordTypeNS    = "NS"
 	RecordTypePTR   = "PTR"
+	RecordTypeMX    = "MX"
+	RecordTypeCAA   = "CAA"
 )
 
 type TTL int64
@@ -147,6 +150,18 @@ func (e *Endpoint) WithProviderSpecific(key, value string) *Endpoint {
 	return e
 }
 
+// SetProviderSpecificProperty sets the value of a provider specific property, replacing the value
+// of an existing property with the same name
+func (e *Endpoint) SetProviderSpecificProperty(key, value string) {
+	for i, providerSpecific := range e.ProviderSpecific {
+		if providerSpecific.Name == key {
+			e.ProviderSpecific[i].Value = value
+			return
+		}
+	}
+	e.WithProviderSpecific(key, value)
+}
+
 func (e *Endpoint) GetProviderSpecificProperty(key string) (ProviderSpecificProperty, bool) {
 	for _, providerSpecific := range e.ProviderSpecific {
 		if providerSpecific.Name == key {
@@ -156,6 +171,27 @@ func (e *Endpoint) GetProviderSpecificProperty(key string) (ProviderSpecificProp
 	return ProviderSpecificProperty{}, false
 }
 
+// DeepCopy returns a copy of the endpoint which shares no targets, labels or
+// provider specific properties with the original
+func (e *Endpoint) DeepCopy() *Endpoint {
+	c := *e
+	if e.Targets != nil {
+		c.Targets = make(Targets, len(e.Targets))
+		copy(c.Targets, e.Targets)
+	}
+	if e.Labels != nil {
+		c.Labels = make(Labels, len(e.Labels))
+		for k, v := range e.Labels {
+			c.Labels[k] = v
+		}
+	}
+	if e.ProviderSpecific != nil {
+		c.ProviderSpecific = make(ProviderSpecific, len(e.ProviderSpecific))
+		copy(c.ProviderSpecific, e.ProviderSpecific)
+	}
+	return &c
+}
+
 func (e *Endpoint) String() string {
 	return fmt.Sprintf("%s %d IN %s %s %s %s", e.DNSName, e.RecordTTL, e.RecordType, e.SetIdentifier, e.Targets, e.ProviderSpecific)
 }
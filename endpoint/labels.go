@@ -21,6 +21,13 @@ const (
 
 	// DualstackLabelKey is the name of the label that identifies dualstack endpoints
 	DualstackLabelKey = "dualstack"
+
+	// PriorityLabelKey is the name of the label holding the priority of an endpoint when resources
+	// conflict, the endpoint with the highest integer priority acquires the DNS name
+	PriorityLabelKey = "priority"
+	// ClaimedAtLabelKey is the name of the label holding the RFC 3339 time at which the resource
+	// of an endpoint claimed the DNS name, the oldest claim acquires the DNS name when resources conflict
+	ClaimedAtLabelKey = "claimed-at"
 )
 
 // Labels store metadata related to the endpoint
@@ -35,8 +42,12 @@ func NewLabels() Labels {
 // NewLabelsFromString constructs endpoints labels from a provided format string
 // if origin set to another value is found then error is returned
 // no origin automatically assumes is not owned by dops and returns invalidOrigin error
+// encrypted labels return ErrEncryptedLabels, see LabelsCipher.NewLabelsFromString
 func NewLabelsFromString(labelText string) (Labels, error) {
 	endpointLabels := map[string]string{}
+	if _, ok := encryptedLabels(labelText); ok {
+		return nil, ErrEncryptedLabels
+	}
 	labelText = strings.Trim(labelText, "\"")
 	tokens := strings.Split(labelText, ",")
 	foundExternalDNSOrigin := false
@@ -0,0 +1,145 @@
+package endpoint
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MXTarget is the parsed form of a MX record target, e.g. "10 mail.example.com"
+type MXTarget struct {
+	Priority uint16
+	Host     string
+}
+
+// ParseMXTarget parses a MX record target of the form "<priority> <host>"
+func ParseMXTarget(target string) (MXTarget, error) {
+	fields := strings.Fields(target)
+	if len(fields) != 2 {
+		return MXTarget{}, fmt.Errorf("invalid MX target %q, expected \"<priority> <host>\"", target)
+	}
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return MXTarget{}, fmt.Errorf("invalid MX priority in %q: %v", target, err)
+	}
+	return MXTarget{Priority: uint16(priority), Host: strings.TrimSuffix(fields[1], ".")}, nil
+}
+
+func (t MXTarget) String() string {
+	return fmt.Sprintf("%d %s", t.Priority, t.Host)
+}
+
+// SRVTarget is the parsed form of a SRV record target, e.g. "10 5 5060 sip.example.com"
+type SRVTarget struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Host     string
+}
+
+// ParseSRVTarget parses a SRV record target of the form "<priority> <weight> <port> <host>"
+func ParseSRVTarget(target string) (SRVTarget, error) {
+	fields := strings.Fields(target)
+	if len(fields) != 4 {
+		return SRVTarget{}, fmt.Errorf("invalid SRV target %q, expected \"<priority> <weight> <port> <host>\"", target)
+	}
+	var values [3]uint16
+	for i, name := range []string{"priority", "weight", "port"} {
+		v, err := strconv.ParseUint(fields[i], 10, 16)
+		if err != nil {
+			return SRVTarget{}, fmt.Errorf("invalid SRV %s in %q: %v", name, target, err)
+		}
+		values[i] = uint16(v)
+	}
+	return SRVTarget{Priority: values[0], Weight: values[1], Port: values[2], Host: strings.TrimSuffix(fields[3], ".")}, nil
+}
+
+func (t SRVTarget) String() string {
+	return fmt.Sprintf("%d %d %d %s", t.Priority, t.Weight, t.Port, t.Host)
+}
+
+// CAATarget is the parsed form of a CAA record target, e.g. `0 issue "letsencrypt.org"`
+type CAATarget struct {
+	Flags uint8
+	Tag   string
+	Value string
+}
+
+// caaTags are the property tags defined by RFC 8659
+var caaTags = map[string]bool{
+	"issue":     true,
+	"issuewild": true,
+	"iodef":     true,
+}
+
+// ParseCAATarget parses a CAA record target of the form "<flags> <tag> <value>",
+// the value may be quoted
+func ParseCAATarget(target string) (CAATarget, error) {
+	fields := strings.SplitN(strings.TrimSpace(target), " ", 3)
+	if len(fields) != 3 {
+		return CAATarget{}, fmt.Errorf("invalid CAA target %q, expected \"<flags> <tag> <value>\"", target)
+	}
+	flags, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil {
+		return CAATarget{}, fmt.Errorf("invalid CAA flags in %q: %v", target, err)
+	}
+	tag := strings.ToLower(fields[1])
+	if !caaTags[tag] {
+		return CAATarget{}, fmt.Errorf("invalid CAA tag %q in %q", fields[1], target)
+	}
+	return CAATarget{Flags: uint8(flags), Tag: tag, Value: strings.Trim(strings.TrimSpace(fields[2]), "\"")}, nil
+}
+
+func (t CAATarget) String() string {
+	return fmt.Sprintf("%d %s \"%s\"", t.Flags, t.Tag, t.Value)
+}
+
+// NormalizeTargets validates the targets of MX, SRV, CAA and TXT endpoints and
+// rewrites them in their canonical form, so that they compare equal to the
+// targets read back from the providers. Targets of other record types are left as is.
+func (e *Endpoint) NormalizeTargets() error {
+	switch e.RecordType {
+	case RecordTypeMX, RecordTypeSRV, RecordTypeCAA, RecordTypeTXT:
+		if len(e.Targets) == 0 {
+			return fmt.Errorf("endpoint %s has no targets", e.DNSName)
+		}
+	default:
+		return nil
+	}
+	normalized := make(Targets, len(e.Targets))
+	for i, target := range e.Targets {
+		switch e.RecordType {
+		case RecordTypeMX:
+			t, err := ParseMXTarget(target)
+			if err != nil {
+				return err
+			}
+			normalized[i] = t.String()
+		case RecordTypeSRV:
+			if labels := strings.SplitN(e.DNSName, ".", 3); len(labels) < 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+				return fmt.Errorf("invalid SRV name %q, expected \"_<service>._<proto>.<name>\"", e.DNSName)
+			}
+			t, err := ParseSRVTarget(target)
+			if err != nil {
+				return err
+			}
+			normalized[i] = t.String()
+		case RecordTypeCAA:
+			t, err := ParseCAATarget(target)
+			if err != nil {
+				return err
+			}
+			normalized[i] = t.String()
+		case RecordTypeTXT:
+			if target == "" {
+				return fmt.Errorf("endpoint %s has an empty TXT target", e.DNSName)
+			}
+			if IsOwnershipText(target) {
+				return fmt.Errorf("TXT target of endpoint %s is reserved for ownership records", e.DNSName)
+			}
+			normalized[i] = target
+		}
+	}
+	e.Targets = normalized
+	return nil
+}
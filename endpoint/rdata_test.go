@@ -0,0 +1,22 @@
+package endpoint
+
+import "testing"
+
+func TestNormalizeTargetsWithoutTargets(t *testing.T) {
+	for recordType, valid := range map[string]bool{
+		RecordTypeA:     true,
+		RecordTypeCNAME: true,
+		RecordTypeMX:    false,
+		RecordTypeSRV:   false,
+		RecordTypeCAA:   false,
+		RecordTypeTXT:   false,
+	} {
+		err := NewEndpoint("_sip._tcp.example.com", recordType).NormalizeTargets()
+		if valid && err != nil {
+			t.Errorf("%s: expected no error, got %v", recordType, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%s: expected an error", recordType)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -21,6 +24,7 @@ import (
 	"github.com/toppr-systems/dops/provider/cloudflare"
 	"github.com/toppr-systems/dops/provider/inmemory"
 	"github.com/toppr-systems/dops/registry"
+	"github.com/toppr-systems/dops/snapshot"
 	"github.com/toppr-systems/dops/source"
 )
 
@@ -50,7 +54,9 @@ func main() {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	go serveMetrics(cfg.MetricsAddress)
+	if cfg.Command == dops.CommandController {
+		go serveMetrics(cfg.MetricsAddress)
+	}
 	go handleSigterm(cancel)
 
 	sourceCfg := &source.Config{
@@ -99,9 +105,46 @@ func main() {
 			},
 		)
 	case "cloudflare":
-		p, err = cloudflare.NewCloudFlareProvider(domainFilter, zoneIDFilter, cfg.CloudflareZonesPerPage, cfg.CloudflareProxied, cfg.DryRun)
+		var cfp *cloudflare.CloudFlareProvider
+		cfp, err = cloudflare.NewCloudFlareProvider(
+			cloudflare.CloudFlareConfig{
+				DomainFilter:      domainFilter,
+				ZoneIDFilter:      zoneIDFilter,
+				ZonesPerPage:      cfg.CloudflareZonesPerPage,
+				ProxiedByDefault:  cfg.CloudflareProxied,
+				DryRun:            cfg.DryRun,
+				RequestsPerSecond: cfg.CloudflareRateLimit,
+				APIRetries:        cfg.CloudflareAPIRetries,
+				ZoneConcurrency:   cfg.CloudflareZoneWorkers,
+				AccountIDs:        cfg.CloudflareAccountIDs,
+				CredentialsFile:   cfg.CloudflareCredentials,
+			},
+		)
+		if err == nil {
+			if permErr := cfp.CheckPermissions(ctx); permErr != nil {
+				log.Warnf("Cloudflare permission check failed: %v", permErr)
+			}
+			p = cfp
+		}
 	case "inmemory":
-		p, err = inmemory.NewInMemoryProvider(inmemory.InMemoryInitZones(cfg.InMemoryZones), inmemory.InMemoryWithDomain(domainFilter), inmemory.InMemoryWithLogging()), nil
+		opts := []inmemory.InMemoryOption{
+			inmemory.InMemoryWithDomain(domainFilter),
+			inmemory.InMemoryWithLogging(),
+			inmemory.InMemoryFailEveryNthApply(cfg.InMemoryFailEveryNth),
+			inmemory.InMemoryFailZones(cfg.InMemoryFailZones),
+			inmemory.InMemoryThrottleEveryNthCall(cfg.InMemoryThrottleEvery),
+			inmemory.InMemoryWithLatency(cfg.InMemoryLatency),
+			inmemory.InMemoryWithRecordsLag(cfg.InMemoryRecordsLag),
+		}
+		if cfg.InMemorySnapshotFile != "" {
+			opts = append(opts, inmemory.InMemoryWithSnapshot(cfg.InMemorySnapshotFile))
+		}
+		opts = append(opts, inmemory.InMemoryInitZones(cfg.InMemoryZones))
+		im := inmemory.NewInMemoryProvider(opts...)
+		err = im.SnapshotError()
+		// expose the in-memory state next to the metrics for inspection
+		http.Handle("/inmemory/", http.StripPrefix("/inmemory", im.InspectionHandler()))
+		p = im
 	default:
 		log.Fatalf("invalid dns provider: %s", cfg.Provider)
 	}
@@ -113,8 +156,10 @@ func main() {
 	switch cfg.Registry {
 	case "noop":
 		r, err = registry.NewNoopRegistry(p)
+	case "file":
+		r, err = newFileRegistry(ctx, p, cfg)
 	case "txt":
-		r, err = registry.NewTXTRegistry(p, cfg.TXTPrefix, cfg.TXTSuffix, cfg.TXTOwnerID, cfg.TXTCacheInterval, cfg.TXTWildcardReplacement)
+		r, err = newTXTRegistry(p, cfg)
 	default:
 		log.Fatalf("invalid registry: %s", cfg.Registry)
 	}
@@ -122,9 +167,27 @@ func main() {
 		log.Fatal(err)
 	}
 
-	policy, exists := plan.Policies[cfg.Policy]
+	policy, err := newPolicy(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	resolver, exists := plan.ConflictResolvers[cfg.ConflictResolver]
 	if !exists {
-		log.Fatalf("invalid policy: %s", cfg.Policy)
+		log.Fatalf("invalid conflict resolver: %s", cfg.ConflictResolver)
+	}
+
+	var deletionGuard *plan.DeletionGuard
+	if cfg.MaxDeletions > 0 || cfg.MaxDeletionsPercent > 0 {
+		deletionGuard = &plan.DeletionGuard{
+			MaxDeletions:        cfg.MaxDeletions,
+			MaxDeletionsPercent: cfg.MaxDeletionsPercent,
+			Override:            cfg.AllowMassDeletion,
+		}
+		// only the records owned by this instance are deleted by the TXT and file registries
+		if cfg.Registry != "noop" {
+			deletionGuard.OwnerID = cfg.TXTOwnerID
+		}
 	}
 
 	ctl := controller.Controller{
@@ -135,6 +198,59 @@ func main() {
 		DomainFilter:         domainFilter,
 		ManagedRecordTypes:   cfg.ManagedDNSRecordTypes,
 		MinEventSyncInterval: cfg.MinEventSyncInterval,
+		DeletionGuard:        deletionGuard,
+		ConflictResolver:     resolver,
+		CleanupOrphans:       cfg.TXTCleanupOrphans,
+	}
+	if cfg.ChangeJournal != "" {
+		ctl.Journal = &plan.Journal{Path: cfg.ChangeJournal, DryRun: cfg.DryRun}
+	}
+
+	switch cfg.Command {
+	case dops.CommandPlan:
+		os.Exit(printPlan(ctx, &ctl, cfg.PlanOutput, cfg.PlanFile))
+	case dops.CommandApply:
+		applyPlan(ctx, &ctl, cfg.PlanFile)
+		os.Exit(0)
+	case dops.CommandExport:
+		if err := exportRecords(ctx, r, cfg); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	case dops.CommandImport:
+		ctl.Source = source.NewSnapshotSource(cfg.SnapshotFile)
+		if err := ctl.RunOnce(ctx); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	case dops.CommandOwnershipList:
+		if err := listOwnership(ctx, r, cfg.OwnershipOutput); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	case dops.CommandOwnershipTransfer:
+		txt, ok := r.(*registry.TXTRegistry)
+		if !ok {
+			log.Fatal("transferring the ownership requires the txt registry")
+		}
+		transferred, err := txt.TransferOwnership(ctx, cfg.OwnershipFrom, cfg.OwnershipTo, endpoint.NewDomainFilter(cfg.OwnershipDomains), policy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		verb := "Transferred"
+		if cfg.DryRun {
+			verb = "Would transfer"
+		}
+		for _, ep := range transferred {
+			log.Infof("%s %s %s from %s to %s", verb, ep.DNSName, ep.RecordType, cfg.OwnershipFrom, cfg.OwnershipTo)
+		}
+		log.Infof("%s %d record(s) from %s to %s", verb, len(transferred), cfg.OwnershipFrom, cfg.OwnershipTo)
+		os.Exit(0)
+	case dops.CommandRollback:
+		if err := ctl.Rollback(ctx, cfg.RollbackTo); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
 	}
 
 	if cfg.Once {
@@ -154,6 +270,113 @@ func main() {
 	ctl.Run(ctx)
 }
 
+// printPlan prints the changes of a single synchronization to stdout and returns
+// the exit code of the plan command, 0 without changes and 2 with changes
+func printPlan(ctx context.Context, ctl *controller.Controller, format, planFile string) int {
+	p, err := ctl.Plan(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if p.Refused != nil {
+		log.Fatal(p.Refused)
+	}
+
+	out := plan.NewOutput(p.Changes)
+	if format == "json" {
+		err = out.WriteJSON(os.Stdout)
+	} else {
+		err = out.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("failed to print plan: %v", err)
+	}
+	if planFile != "" {
+		if err := p.Save(planFile); err != nil {
+			log.Fatalf("failed to save plan: %v", err)
+		}
+		log.Infof("Saved plan to %s", planFile)
+	}
+
+	if len(out.Changes) > 0 {
+		return 2
+	}
+	return 0
+}
+
+// applyPlan applies a saved plan, printing the records which changed since
+// the plan was calculated to stderr if it is stale
+func applyPlan(ctx context.Context, ctl *controller.Controller, planFile string) {
+	saved, err := plan.LoadSavedPlan(planFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = ctl.ApplyPlan(ctx, saved)
+	var stale *plan.StalePlanError
+	if errors.As(err, &stale) {
+		fmt.Fprintf(os.Stderr, "Records changed since the plan was calculated at %s:\n\n", saved.CreatedAt.Format(time.RFC3339))
+		if err := plan.NewOutput(stale.Drift).WriteTable(os.Stderr); err != nil {
+			log.Error(err)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// listOwnership prints the managed names of the registry with their owners
+func listOwnership(ctx context.Context, r registry.Registry, format string) error {
+	records, err := r.Records(ctx)
+	if err != nil {
+		return err
+	}
+	list := registry.ListOwnership(records)
+	if format == "json" {
+		return registry.WriteOwnershipJSON(os.Stdout, list)
+	}
+	return registry.WriteOwnershipTable(os.Stdout, list)
+}
+
+// exportRecords writes the records of the registry, or only the owned ones, to a snapshot
+func exportRecords(ctx context.Context, r registry.Registry, cfg *dops.Config) error {
+	records, err := r.Records(ctx)
+	if err != nil {
+		return err
+	}
+	if cfg.ExportOwnedOnly {
+		if cfg.Registry == "noop" {
+			return errors.New("exporting only the owned records requires the txt or file registry")
+		}
+		owned := []*endpoint.Endpoint{}
+		for _, ep := range records {
+			if ep.Labels[endpoint.OwnerLabelKey] == cfg.TXTOwnerID {
+				owned = append(owned, ep)
+			}
+		}
+		records = owned
+	}
+
+	w := os.Stdout
+	if cfg.SnapshotFile != "" {
+		if w, err = os.Create(cfg.SnapshotFile); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+
+	snap := snapshot.New(records)
+	if cfg.SnapshotFormat == "bind" {
+		err = snap.WriteBIND(w)
+	} else {
+		err = snap.WriteJSON(w)
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("Exported %d record(s)", len(records))
+	return nil
+}
+
 func handleSigterm(cancel func()) {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM)
@@ -172,3 +395,99 @@ func serveMetrics(address string) {
 
 	log.Fatal(http.ListenAndServe(address, nil))
 }
+
+// newTXTRegistry returns the TXT registry configured by the txt flags
+func newTXTRegistry(p provider.Provider, cfg *dops.Config) (*registry.TXTRegistry, error) {
+	labelsCipher, err := newLabelsCipher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return registry.NewTXTRegistry(p, cfg.TXTPrefix, cfg.TXTSuffix, cfg.TXTOwnerID, cfg.TXTCacheInterval, cfg.TXTWildcardReplacement, cfg.TXTFormat, labelsCipher)
+}
+
+// newFileRegistry returns the file registry, importing the ownership from the TXT registry
+// on the first start if requested
+func newFileRegistry(ctx context.Context, p provider.Provider, cfg *dops.Config) (*registry.FileRegistry, error) {
+	r, err := registry.NewFileRegistry(p, cfg.TXTOwnerID, cfg.RegistryStateFile, cfg.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.RegistryImportTXT || r.Exists() {
+		return r, nil
+	}
+	txt, err := newTXTRegistry(p, cfg)
+	if err != nil {
+		return nil, err
+	}
+	imported, err := r.Import(ctx, txt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import the ownership from TXT records: %v", err)
+	}
+	log.Infof("Imported the ownership of %d record(s) from TXT records into %s", imported, cfg.RegistryStateFile)
+	return r, nil
+}
+
+// newLabelsCipher returns the cipher for the ownership records, or nil if no encryption key is configured
+func newLabelsCipher(cfg *dops.Config) (*endpoint.LabelsCipher, error) {
+	keys := [][]byte{}
+	for _, text := range cfg.TXTEncryptionKeys {
+		parsed, err := endpoint.ParseLabelsKeys(text)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, parsed...)
+	}
+	if cfg.TXTEncryptionKeyFile != "" {
+		data, err := os.ReadFile(cfg.TXTEncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := endpoint.ParseLabelsKeys(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.TXTEncryptionKeyFile, err)
+		}
+		keys = append(keys, parsed...)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return endpoint.NewLabelsCipher(keys)
+}
+
+// newPolicy returns the global policy, wrapped by the domain policy overrides and the
+// protection of the protected names if any are configured
+func newPolicy(cfg *dops.Config) (plan.Policy, error) {
+	policy, exists := plan.Policies[cfg.Policy]
+	if !exists {
+		return nil, fmt.Errorf("invalid policy: %s", cfg.Policy)
+	}
+
+	if len(cfg.DomainPolicies) > 0 {
+		perDomain := &plan.PerDomainPolicy{Default: policy}
+		for _, override := range cfg.DomainPolicies {
+			parts := strings.SplitN(override, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("invalid domain policy %q, expected <domain>=<policy>", override)
+			}
+			domainPolicy, exists := plan.Policies[parts[1]]
+			if !exists {
+				return nil, fmt.Errorf("invalid policy %q for domain %s", parts[1], parts[0])
+			}
+			perDomain.Domains = append(perDomain.Domains, plan.DomainPolicy{Domain: parts[0], Policy: domainPolicy})
+		}
+		policy = perDomain
+	}
+
+	if len(cfg.ProtectedNames) > 0 {
+		protected := &plan.ProtectedNamesPolicy{}
+		for _, p := range cfg.ProtectedNames {
+			pattern, err := plan.NewNamePattern(p)
+			if err != nil {
+				return nil, err
+			}
+			protected.Patterns = append(protected.Patterns, pattern)
+		}
+		policy = plan.PolicyChain{policy, protected}
+	}
+	return policy, nil
+}
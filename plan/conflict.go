@@ -2,6 +2,10 @@ package plan
 
 import (
 	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 
 	"github.com/toppr-systems/dops/endpoint"
 )
@@ -13,12 +17,36 @@ type ConflictResolver interface {
 	ResolveUpdate(current *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint
 }
 
+// ConflictResolvers is a registry of available conflict resolvers.
+var ConflictResolvers = map[string]ConflictResolver{
+	"per-resource":  PerResource{},
+	"priority":      PerPriority{},
+	"merge-targets": MergeTargets{},
+	"oldest-claim":  OldestClaim{},
+}
+
 // PerResource allows only one resource to own a given dns name
 type PerResource struct{}
 
 // ResolveCreate is invoked when dns name is not owned by any resource
 // ResolveCreate takes "minimal" (string comparison of Target) endpoint to acquire the DNS record
 func (s PerResource) ResolveCreate(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	winner := s.resolveCreate(candidates)
+	logResolution("per-resource", winner, candidates)
+	return winner
+}
+
+// ResolveUpdate is invoked when dns name is already owned by "current" endpoint
+// ResolveUpdate uses "current" record as base and updates it accordingly with new version of same resource
+// if it doesn't exist then pick min
+func (s PerResource) ResolveUpdate(current *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	winner := s.resolveUpdate(current, candidates)
+	logResolution("per-resource", winner, candidates)
+	return winner
+}
+
+// resolveCreate is ResolveCreate without logging, for the resolvers breaking their ties with PerResource
+func (s PerResource) resolveCreate(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
 	var min *endpoint.Endpoint
 	for _, ep := range candidates {
 		if min == nil || s.less(ep, min) {
@@ -28,10 +56,8 @@ func (s PerResource) ResolveCreate(candidates []*endpoint.Endpoint) *endpoint.En
 	return min
 }
 
-// ResolveUpdate is invoked when dns name is already owned by "current" endpoint
-// ResolveUpdate uses "current" record as base and updates it accordingly with new version of same resource
-// if it doesn't exist then pick min
-func (s PerResource) ResolveUpdate(current *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+// resolveUpdate is ResolveUpdate without logging
+func (s PerResource) resolveUpdate(current *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
 	currentResource := current.Labels[endpoint.ResourceLabelKey] // resource which has already acquired the DNS
 	// TODO: sort candidates only needed because we can still have two endpoints from same resource here. We sort for consistency
 	// TODO: remove once single endpoint can have multiple targets
@@ -43,7 +69,7 @@ func (s PerResource) ResolveUpdate(current *endpoint.Endpoint, candidates []*end
 			return ep
 		}
 	}
-	return s.ResolveCreate(candidates)
+	return s.resolveCreate(candidates)
 }
 
 // less returns true if endpoint x is less than y
@@ -51,4 +77,168 @@ func (s PerResource) less(x, y *endpoint.Endpoint) bool {
 	return x.Targets.IsLess(y.Targets)
 }
 
-// TODO: with cross-resource/cross-cluster setup alternative variations of ConflictResolver can be used
+// PerPriority gives the dns name to the resource with the highest priority label,
+// endpoints without a valid priority have priority 0. Ties are resolved by PerResource.
+type PerPriority struct{}
+
+// ResolveCreate takes the endpoint with the highest priority
+func (s PerPriority) ResolveCreate(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	winner := PerResource{}.resolveCreate(highestPriority(candidates))
+	logResolution("priority", winner, candidates)
+	return winner
+}
+
+// ResolveUpdate takes the endpoint with the highest priority, preferring the resource owning the current record
+func (s PerPriority) ResolveUpdate(current *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	winner := PerResource{}.resolveUpdate(current, highestPriority(candidates))
+	logResolution("priority", winner, candidates)
+	return winner
+}
+
+// highestPriority returns the candidates sharing the highest priority
+func highestPriority(candidates []*endpoint.Endpoint) []*endpoint.Endpoint {
+	var (
+		best     []*endpoint.Endpoint
+		bestPrio int
+	)
+	for _, ep := range candidates {
+		prio, _ := strconv.Atoi(ep.Labels[endpoint.PriorityLabelKey])
+		if len(best) == 0 || prio > bestPrio {
+			best, bestPrio = []*endpoint.Endpoint{ep}, prio
+		} else if prio == bestPrio {
+			best = append(best, ep)
+		}
+	}
+	return best
+}
+
+// MergeTargets merges the targets of all A or AAAA candidates into a single endpoint,
+// taking the labels and TTL of the endpoint PerResource would choose.
+// Candidates of other types can not be merged and are resolved by PerResource.
+type MergeTargets struct{}
+
+// ResolveCreate merges the candidates
+func (s MergeTargets) ResolveCreate(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	base := PerResource{}.resolveCreate(candidates)
+	return s.merge(base, candidates)
+}
+
+// ResolveUpdate merges the candidates into the endpoint of the resource owning the current record
+func (s MergeTargets) ResolveUpdate(current *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	base := PerResource{}.resolveUpdate(current, candidates)
+	return s.merge(base, candidates)
+}
+
+// merge merges the candidates into base and logs the merge, or the resolution by PerResource
+// if the candidates can not be merged
+func (s MergeTargets) merge(base *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	merged := mergeTargets(base, candidates)
+	if merged == base {
+		logResolution("merge-targets", base, candidates)
+		return base
+	}
+	resources := []string{}
+	for _, ep := range candidates {
+		resources = append(resources, describeCandidate(ep))
+	}
+	log.WithFields(log.Fields{
+		"strategy": "merge-targets",
+		"dnsName":  merged.DNSName,
+		"merged":   resources,
+		"targets":  merged.Targets.String(),
+	}).Info("Merged the targets of resources claiming the same DNS name")
+	return merged
+}
+
+func mergeTargets(base *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	if len(candidates) < 2 {
+		return base
+	}
+	for _, ep := range candidates {
+		if ep.RecordType != base.RecordType || (ep.RecordType != endpoint.RecordTypeA && ep.RecordType != endpoint.RecordTypeAAAA) {
+			return base
+		}
+	}
+
+	seen := map[string]bool{}
+	targets := endpoint.Targets{}
+	for _, ep := range candidates {
+		for _, t := range ep.Targets {
+			if !seen[t] {
+				seen[t] = true
+				targets = append(targets, t)
+			}
+		}
+	}
+	sort.Strings(targets)
+
+	merged := base.DeepCopy()
+	merged.Targets = targets
+	return merged
+}
+
+// OldestClaim gives the dns name to the resource with the oldest claimed-at label,
+// endpoints without a valid claim time come last. Ties are resolved by PerResource.
+type OldestClaim struct{}
+
+// ResolveCreate takes the endpoint with the oldest claim
+func (s OldestClaim) ResolveCreate(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	winner := PerResource{}.resolveCreate(oldestClaims(candidates))
+	logResolution("oldest-claim", winner, candidates)
+	return winner
+}
+
+// ResolveUpdate takes the endpoint with the oldest claim, preferring the resource owning the current record
+func (s OldestClaim) ResolveUpdate(current *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	winner := PerResource{}.resolveUpdate(current, oldestClaims(candidates))
+	logResolution("oldest-claim", winner, candidates)
+	return winner
+}
+
+// oldestClaims returns the candidates sharing the oldest claim time
+func oldestClaims(candidates []*endpoint.Endpoint) []*endpoint.Endpoint {
+	var (
+		oldest   []*endpoint.Endpoint
+		oldestAt time.Time
+	)
+	for _, ep := range candidates {
+		claimedAt, err := time.Parse(time.RFC3339, ep.Labels[endpoint.ClaimedAtLabelKey])
+		if err != nil {
+			// unclaimed endpoints lose against any valid claim
+			claimedAt = time.Unix(1<<62, 0)
+		}
+		if len(oldest) == 0 || claimedAt.Before(oldestAt) {
+			oldest, oldestAt = []*endpoint.Endpoint{ep}, claimedAt
+		} else if claimedAt.Equal(oldestAt) {
+			oldest = append(oldest, ep)
+		}
+	}
+	return oldest
+}
+
+// logResolution logs the outcome of a conflict between several candidates
+func logResolution(strategy string, winner *endpoint.Endpoint, candidates []*endpoint.Endpoint) {
+	if len(candidates) < 2 {
+		return
+	}
+	losers := []string{}
+	for _, ep := range candidates {
+		if ep != winner {
+			losers = append(losers, describeCandidate(ep))
+		}
+	}
+	log.WithFields(log.Fields{
+		"strategy": strategy,
+		"dnsName":  winner.DNSName,
+		"winner":   describeCandidate(winner),
+		"losers":   losers,
+	}).Info("Resolved conflict between resources claiming the same DNS name")
+}
+
+func describeCandidate(ep *endpoint.Endpoint) string {
+	resource := ep.Labels[endpoint.ResourceLabelKey]
+	if resource == "" {
+		resource = "unknown resource"
+	}
+	return resource + " (" + ep.RecordType + " " + ep.Targets.String() + ")"
+}
@@ -0,0 +1,71 @@
+package plan
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+func candidate(resource, recordType, target string) *endpoint.Endpoint {
+	ep := endpoint.NewEndpoint("foo.example.com", recordType, target)
+	ep.Labels[endpoint.ResourceLabelKey] = resource
+	return ep
+}
+
+func TestConflictResolversLogResolution(t *testing.T) {
+	for _, tc := range []struct {
+		resolver   ConflictResolver
+		candidates []*endpoint.Endpoint
+		merged     bool
+	}{
+		{
+			PerResource{},
+			[]*endpoint.Endpoint{candidate("ingress/a", endpoint.RecordTypeA, "192.0.2.1"), candidate("ingress/b", endpoint.RecordTypeA, "192.0.2.2")},
+			false,
+		},
+		{
+			PerPriority{},
+			[]*endpoint.Endpoint{candidate("ingress/a", endpoint.RecordTypeA, "192.0.2.1"), candidate("ingress/b", endpoint.RecordTypeA, "192.0.2.2")},
+			false,
+		},
+		{
+			MergeTargets{},
+			[]*endpoint.Endpoint{candidate("ingress/a", endpoint.RecordTypeA, "192.0.2.1"), candidate("ingress/b", endpoint.RecordTypeA, "192.0.2.2")},
+			true,
+		},
+		{
+			MergeTargets{},
+			[]*endpoint.Endpoint{candidate("ingress/a", endpoint.RecordTypeCNAME, "a.example.net"), candidate("ingress/b", endpoint.RecordTypeCNAME, "b.example.net")},
+			false,
+		},
+	} {
+		hook := test.NewGlobal()
+		log.SetLevel(log.InfoLevel)
+
+		tc.resolver.ResolveCreate(tc.candidates)
+		current := candidate("ingress/b", tc.candidates[0].RecordType, "192.0.2.3")
+		tc.resolver.ResolveUpdate(current, tc.candidates)
+
+		entries := hook.AllEntries()
+		if len(entries) != 2 {
+			t.Errorf("%T: expected one entry per resolution, got %d", tc.resolver, len(entries))
+			continue
+		}
+		message := "Resolved conflict between resources claiming the same DNS name"
+		if tc.merged {
+			message = "Merged the targets of resources claiming the same DNS name"
+		}
+		for _, entry := range entries {
+			if entry.Message != message {
+				t.Errorf("%T: expected %q, got %q", tc.resolver, message, entry.Message)
+			}
+			if _, losers := entry.Data["losers"]; losers == tc.merged {
+				t.Errorf("%T: unexpected fields %v", tc.resolver, entry.Data)
+			}
+		}
+		hook.Reset()
+	}
+}
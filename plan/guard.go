@@ -0,0 +1,71 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// ErrTooManyDeletions is returned when a plan deletes more records than the DeletionGuard allows
+var ErrTooManyDeletions = errors.New("too many deletions")
+
+// DeletionGuard caps the number of records a single plan may delete, which protects
+// against a broken or empty source deleting all records in one synchronization.
+// A plan exceeding either cap is refused as a whole.
+type DeletionGuard struct {
+	// MaxDeletions is the maximum number of records deleted per plan, 0 disables the cap
+	MaxDeletions int
+	// MaxDeletionsPercent is the maximum percentage of the owned records deleted per plan, 0 disables the cap
+	MaxDeletionsPercent float64
+	// OwnerID limits the counted records to the ones owned by this instance, all records count if empty
+	OwnerID string
+	// Override lets plans exceeding the caps through, for intentional bulk cleanups
+	Override bool
+}
+
+// Check returns an error wrapping ErrTooManyDeletions if the changes delete more
+// of the current records than allowed
+func (g *DeletionGuard) Check(changes *Changes, current []*endpoint.Endpoint) error {
+	deletions := len(g.owned(changes.Delete))
+	if deletions == 0 {
+		return nil
+	}
+	owned := len(g.owned(current))
+
+	var err error
+	if g.MaxDeletions > 0 && deletions > g.MaxDeletions {
+		err = fmt.Errorf("%w: %d of %d owned records, the maximum is %d", ErrTooManyDeletions, deletions, owned, g.MaxDeletions)
+	} else if g.MaxDeletionsPercent > 0 && owned > 0 && float64(deletions)*100/float64(owned) > g.MaxDeletionsPercent {
+		err = fmt.Errorf("%w: %d of %d owned records, the maximum is %g%%", ErrTooManyDeletions, deletions, owned, g.MaxDeletionsPercent)
+	}
+	if err == nil {
+		return nil
+	}
+
+	fields := log.Fields{
+		"deletions":    deletions,
+		"ownedRecords": owned,
+	}
+	if g.Override {
+		log.WithFields(fields).Warnf("Deletion guard overridden, applying plan: %v", err)
+		return nil
+	}
+	log.WithFields(fields).Errorf("Deletion guard refused plan: %v", err)
+	return err
+}
+
+func (g *DeletionGuard) owned(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	if g.OwnerID == "" {
+		return endpoints
+	}
+	owned := []*endpoint.Endpoint{}
+	for _, ep := range endpoints {
+		if ep.Labels[endpoint.OwnerLabelKey] == g.OwnerID {
+			owned = append(owned, ep)
+		}
+	}
+	return owned
+}
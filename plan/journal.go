@@ -0,0 +1,205 @@
+package plan
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// JournalEntry holds the changes applied in one reconcile cycle
+type JournalEntry struct {
+	Time time.Time `json:"time"`
+	// Cycle numbers the entries of a journal, starting at 1
+	Cycle int `json:"cycle"`
+	// DryRun is set if the changes were only logged by the provider
+	DryRun  bool     `json:"dryRun,omitempty"`
+	Changes *Changes `json:"changes"`
+}
+
+// Journal appends the applied changes, with their reasons, as JSON lines to a file
+type Journal struct {
+	// Path of the journal file, created if missing
+	Path string
+	// DryRun marks the entries as not actually applied
+	DryRun bool
+
+	mu        sync.Mutex
+	lastCycle int
+	loaded    bool
+}
+
+// Append writes an entry for the changes and returns its cycle
+func (j *Journal) Append(changes *Changes) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.loaded {
+		entries, err := ReadJournal(j.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		if len(entries) > 0 {
+			j.lastCycle = entries[len(entries)-1].Cycle
+		}
+		j.loaded = true
+	}
+
+	data, err := json.Marshal(JournalEntry{
+		Time:    time.Now().UTC(),
+		Cycle:   j.lastCycle + 1,
+		DryRun:  j.DryRun,
+		Changes: changes,
+	})
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(j.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	j.lastCycle++
+	return j.lastCycle, nil
+}
+
+// ReadJournal reads the entries of a journal file in the order they were written
+func ReadJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse line %d of journal %s: %v", line, path, err)
+		}
+		if entry.Changes == nil {
+			entry.Changes = &Changes{}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// RollbackChanges returns the changes which undo the cycles applied after the given cycle,
+// newest first. They are meant to be applied one after the other.
+func RollbackChanges(entries []JournalEntry, to int) ([]*Changes, error) {
+	found := false
+	for _, entry := range entries {
+		if entry.Cycle == to {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("cycle %d not found in journal", to)
+	}
+
+	rollback := []*Changes{}
+	for i := len(entries) - 1; i >= 0 && entries[i].Cycle > to; i-- {
+		if entries[i].DryRun || !entries[i].Changes.HasChanges() {
+			continue
+		}
+		rollback = append(rollback, entries[i].Changes.Inverse(fmt.Sprintf("rollback of cycle %d", entries[i].Cycle)))
+	}
+	return rollback, nil
+}
+
+// Inverse returns the changes which undo the changes, with the given reason
+func (c *Changes) Inverse(reason string) *Changes {
+	inverse := &Changes{
+		Create:     c.Delete,
+		UpdateOld:  c.UpdateNew,
+		UpdateNew:  c.UpdateOld,
+		ReplaceOld: c.ReplaceNew,
+		ReplaceNew: c.ReplaceOld,
+		Delete:     c.Create,
+		Reasons:    Reasons{},
+	}
+	set := func(action string, endpoints []*endpoint.Endpoint) {
+		for _, ep := range endpoints {
+			inverse.Reasons.Set(action, ep, reason)
+		}
+	}
+	set(ActionCreate, inverse.Create)
+	set(ActionUpdate, inverse.UpdateNew)
+	set(ActionReplace, inverse.ReplaceNew)
+	set(ActionDelete, inverse.Delete)
+	return inverse
+}
+
+// KeepApplicable drops the changes which do not fit the current records, i.e. creations of
+// existing records and updates, replacements and deletions of records which are missing or
+// whose targets differ. Changes the registry refused to apply end up in the journal as well,
+// their inverse does not fit the current records.
+func KeepApplicable(changes *Changes, current []*endpoint.Endpoint) *Changes {
+	records := map[string]*endpoint.Endpoint{}
+	for _, ep := range current {
+		records[recordID(ep)] = ep
+	}
+	matches := func(ep *endpoint.Endpoint) bool {
+		rec, ok := records[recordID(ep)]
+		return ok && rec.Targets.Same(ep.Targets)
+	}
+	skip := func(action string, ep *endpoint.Endpoint) {
+		log.WithFields(log.Fields{
+			"action": action,
+			"record": ep.DNSName,
+			"type":   ep.RecordType,
+			"setID":  ep.SetIdentifier,
+		}).Warn("Skipping change which does not match the current records")
+	}
+
+	applicable := &Changes{Reasons: changes.Reasons}
+	for _, ep := range changes.Create {
+		if _, exists := records[recordID(ep)]; exists {
+			skip(ActionCreate, ep)
+			continue
+		}
+		applicable.Create = append(applicable.Create, ep)
+	}
+	for i, ep := range changes.UpdateNew {
+		if !matches(changes.UpdateOld[i]) {
+			skip(ActionUpdate, ep)
+			continue
+		}
+		applicable.UpdateOld = append(applicable.UpdateOld, changes.UpdateOld[i])
+		applicable.UpdateNew = append(applicable.UpdateNew, ep)
+	}
+	for i, ep := range changes.ReplaceNew {
+		if !matches(changes.ReplaceOld[i]) {
+			skip(ActionReplace, ep)
+			continue
+		}
+		applicable.ReplaceOld = append(applicable.ReplaceOld, changes.ReplaceOld[i])
+		applicable.ReplaceNew = append(applicable.ReplaceNew, ep)
+	}
+	for _, ep := range changes.Delete {
+		if !matches(ep) {
+			skip(ActionDelete, ep)
+			continue
+		}
+		applicable.Delete = append(applicable.Delete, ep)
+	}
+	return applicable
+}
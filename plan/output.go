@@ -0,0 +1,235 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// Actions of a Change
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	// ActionReplace deletes the current record before creating the desired one of another type
+	ActionReplace = "replace"
+)
+
+// Record is the state of a record in the machine-readable output of a plan
+type Record struct {
+	TTL              endpoint.TTL      `json:"ttl,omitempty"`
+	Targets          endpoint.Targets  `json:"targets"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	ProviderSpecific map[string]string `json:"providerSpecific,omitempty"`
+}
+
+// Change is a single change of a plan in its machine-readable output
+type Change struct {
+	Action        string `json:"action"`
+	DNSName       string `json:"dnsName"`
+	RecordType    string `json:"recordType"`
+	SetIdentifier string `json:"setIdentifier,omitempty"`
+	// OldRecordType is the type of the current record, set for replacements
+	OldRecordType string `json:"oldRecordType,omitempty"`
+	// Reason explains why the change was planned
+	Reason string `json:"reason,omitempty"`
+	// Old is the current record, set for updates and deletions
+	Old *Record `json:"old,omitempty"`
+	// New is the desired record, set for creations and updates
+	New *Record `json:"new,omitempty"`
+}
+
+// Summary counts the changes of a plan by action
+type Summary struct {
+	Create  int `json:"create"`
+	Update  int `json:"update"`
+	Replace int `json:"replace"`
+	Delete  int `json:"delete"`
+}
+
+// Output is the machine-readable form of the changes of a plan
+type Output struct {
+	Changes []Change `json:"changes"`
+	Summary Summary  `json:"summary"`
+}
+
+// NewOutput returns the machine-readable form of the changes, sorted by name and type
+func NewOutput(changes *Changes) Output {
+	out := Output{Changes: []Change{}}
+	for _, ep := range changes.Create {
+		out.Changes = append(out.Changes, newChange(ActionCreate, nil, ep, changes.Reason(ActionCreate, ep)))
+	}
+	for i, ep := range changes.UpdateNew {
+		out.Changes = append(out.Changes, newChange(ActionUpdate, changes.UpdateOld[i], ep, changes.Reason(ActionUpdate, ep)))
+	}
+	for i, ep := range changes.ReplaceNew {
+		c := newChange(ActionReplace, changes.ReplaceOld[i], ep, changes.Reason(ActionReplace, ep))
+		c.OldRecordType = changes.ReplaceOld[i].RecordType
+		out.Changes = append(out.Changes, c)
+	}
+	for _, ep := range changes.Delete {
+		out.Changes = append(out.Changes, newChange(ActionDelete, ep, nil, changes.Reason(ActionDelete, ep)))
+	}
+	out.Summary = Summary{
+		Create:  len(changes.Create),
+		Update:  len(changes.UpdateNew),
+		Replace: len(changes.ReplaceNew),
+		Delete:  len(changes.Delete),
+	}
+
+	sort.SliceStable(out.Changes, func(i, j int) bool {
+		a, b := out.Changes[i], out.Changes[j]
+		if a.DNSName != b.DNSName {
+			return a.DNSName < b.DNSName
+		}
+		if a.RecordType != b.RecordType {
+			return a.RecordType < b.RecordType
+		}
+		return a.SetIdentifier < b.SetIdentifier
+	})
+	return out
+}
+
+func newChange(action string, current, desired *endpoint.Endpoint, reason string) Change {
+	ep := desired
+	if ep == nil {
+		ep = current
+	}
+	return Change{
+		Action:        action,
+		DNSName:       ep.DNSName,
+		RecordType:    ep.RecordType,
+		SetIdentifier: ep.SetIdentifier,
+		Reason:        reason,
+		Old:           newRecord(current),
+		New:           newRecord(desired),
+	}
+}
+
+func newRecord(ep *endpoint.Endpoint) *Record {
+	if ep == nil {
+		return nil
+	}
+	r := &Record{
+		TTL:     ep.RecordTTL,
+		Targets: ep.Targets,
+	}
+	if len(ep.Labels) > 0 {
+		r.Labels = ep.Labels
+	}
+	if len(ep.ProviderSpecific) > 0 {
+		r.ProviderSpecific = map[string]string{}
+		for _, p := range ep.ProviderSpecific {
+			r.ProviderSpecific[p.Name] = p.Value
+		}
+	}
+	return r
+}
+
+// WriteJSON writes the output as indented JSON
+func (o Output) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(o)
+}
+
+// WriteTable writes the output in a human-readable form, one line per change prefixed
+// with +, ~, -/+ or - for creations, updates, replacements and deletions. Updates and
+// replacements are followed by the attributes which differ, as "<attribute>: <old> -> <new>",
+// and every change by its reason, as "# <reason>".
+func (o Output) WriteTable(w io.Writer) error {
+	b := &strings.Builder{}
+	for _, c := range o.Changes {
+		name := c.DNSName
+		if c.SetIdentifier != "" {
+			name += " (" + c.SetIdentifier + ")"
+		}
+		switch c.Action {
+		case ActionCreate:
+			fmt.Fprintf(b, "+ %s %s %s\n", name, c.RecordType, c.New.describe())
+		case ActionDelete:
+			fmt.Fprintf(b, "- %s %s %s\n", name, c.RecordType, c.Old.describe())
+		case ActionUpdate:
+			fmt.Fprintf(b, "~ %s %s\n", name, c.RecordType)
+			for _, d := range c.Old.diff(c.New) {
+				fmt.Fprintf(b, "    %s\n", d)
+			}
+		case ActionReplace:
+			fmt.Fprintf(b, "-/+ %s %s -> %s\n", name, c.OldRecordType, c.RecordType)
+			for _, d := range c.Old.diff(c.New) {
+				fmt.Fprintf(b, "    %s\n", d)
+			}
+		}
+		if c.Reason != "" {
+			fmt.Fprintf(b, "    # %s\n", c.Reason)
+		}
+	}
+	if len(o.Changes) == 0 {
+		b.WriteString("No changes, all records are up to date.\n")
+	} else {
+		replace := ""
+		if o.Summary.Replace > 0 {
+			replace = fmt.Sprintf(" %d to replace,", o.Summary.Replace)
+		}
+		fmt.Fprintf(b, "\nPlan: %d to create, %d to update,%s %d to delete.\n", o.Summary.Create, o.Summary.Update, replace, o.Summary.Delete)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (r *Record) describe() string {
+	parts := []string{}
+	if r.TTL.IsConfigured() {
+		parts = append(parts, fmt.Sprintf("ttl=%d", r.TTL))
+	}
+	parts = append(parts, "targets="+r.Targets.String())
+	if owner := r.Labels[endpoint.OwnerLabelKey]; owner != "" {
+		parts = append(parts, "owner="+owner)
+	}
+	for _, name := range sortedKeys(r.ProviderSpecific) {
+		parts = append(parts, name+"="+r.ProviderSpecific[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+// diff returns the attributes which differ between the records, as "<attribute>: <old> -> <new>"
+func (r *Record) diff(desired *Record) []string {
+	diffs := []string{}
+	add := func(name, from, to string) {
+		if from != to {
+			diffs = append(diffs, fmt.Sprintf("%s: %s -> %s", name, from, to))
+		}
+	}
+
+	if desired.TTL.IsConfigured() {
+		add("ttl", fmt.Sprint(r.TTL), fmt.Sprint(desired.TTL))
+	}
+	if !r.Targets.Same(desired.Targets) {
+		add("targets", r.Targets.String(), desired.Targets.String())
+	}
+	add("owner", r.Labels[endpoint.OwnerLabelKey], desired.Labels[endpoint.OwnerLabelKey])
+
+	names := sortedKeys(r.ProviderSpecific)
+	for _, name := range sortedKeys(desired.ProviderSpecific) {
+		if _, ok := r.ProviderSpecific[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		add(name, r.ProviderSpecific[name], desired.ProviderSpecific[name])
+	}
+	return diffs
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
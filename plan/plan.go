@@ -32,18 +32,34 @@ type Plan struct {
 	PropertyComparator PropertyComparator
 	// DNS record types that will be considered for management
 	ManagedRecords []string
+	// DeletionGuard caps the number of deletions, if set
+	DeletionGuard *DeletionGuard
+	// ConflictResolver picks the record among several resources claiming the same DNS name,
+	// PerResource if not set
+	ConflictResolver ConflictResolver
+	// Rejected holds the desired records Calculate dropped because they conflict with other records
+	Rejected []Rejection
+	// Refused is set by Calculate when the DeletionGuard refused the changes,
+	// Changes is empty in that case
+	Refused error
 }
 
 // Changes holds lists of actions to be executed by dns providers
 type Changes struct {
 	// Records that need to be created
-	Create []*endpoint.Endpoint
+	Create []*endpoint.Endpoint `json:"create,omitempty"`
 	// Records that need to be updated (current data)
-	UpdateOld []*endpoint.Endpoint
+	UpdateOld []*endpoint.Endpoint `json:"updateOld,omitempty"`
 	// Records that need to be updated (desired data)
-	UpdateNew []*endpoint.Endpoint
+	UpdateNew []*endpoint.Endpoint `json:"updateNew,omitempty"`
 	// Records that need to be deleted
-	Delete []*endpoint.Endpoint
+	Delete []*endpoint.Endpoint `json:"delete,omitempty"`
+	// Records whose type changes (current data), deleted before the desired records are created
+	ReplaceOld []*endpoint.Endpoint `json:"replaceOld,omitempty"`
+	// Records whose type changes (desired data), created once the current records are deleted
+	ReplaceNew []*endpoint.Endpoint `json:"replaceNew,omitempty"`
+	// Reasons explains why each change was planned
+	Reasons Reasons `json:"reasons,omitempty"`
 }
 
 // planTable is a supplementary struct for Plan
@@ -60,12 +76,31 @@ bar.com |                | [->191.1.1.1, ->190.1.1.1]  |  = create (bar.com -> 1
 "=", i.e. result of calculation relies on supplied ConflictResolver
 */
 type planTable struct {
-	rows     map[string]map[string]*planTableRow
+	rows     map[string]map[planKey]*planTableRow
 	resolver ConflictResolver
 }
 
-func newPlanTable() planTable {
-	return planTable{map[string]map[string]*planTableRow{}, PerResource{}}
+func newPlanTable(resolver ConflictResolver) planTable {
+	if resolver == nil {
+		resolver = PerResource{}
+	}
+	return planTable{map[string]map[planKey]*planTableRow{}, resolver}
+}
+
+// planKey identifies a row within a dnsName
+// A and CNAME records share a row, so that a change between the two is planned
+// as an update; every other record type is planned in a row of its own
+type planKey struct {
+	setIdentifier string
+	recordType    string
+}
+
+func newPlanKey(e *endpoint.Endpoint) planKey {
+	key := planKey{setIdentifier: e.SetIdentifier}
+	if e.RecordType != endpoint.RecordTypeA && e.RecordType != endpoint.RecordTypeCNAME {
+		key.recordType = e.RecordType
+	}
+	return key
 }
 
 // planTableRow
@@ -82,28 +117,30 @@ func (t planTableRow) String() string {
 
 func (t planTable) addCurrent(e *endpoint.Endpoint) {
 	dnsName := normalizeDNSName(e.DNSName)
+	key := newPlanKey(e)
 	if _, ok := t.rows[dnsName]; !ok {
-		t.rows[dnsName] = make(map[string]*planTableRow)
+		t.rows[dnsName] = make(map[planKey]*planTableRow)
 	}
-	if _, ok := t.rows[dnsName][e.SetIdentifier]; !ok {
-		t.rows[dnsName][e.SetIdentifier] = &planTableRow{}
+	if _, ok := t.rows[dnsName][key]; !ok {
+		t.rows[dnsName][key] = &planTableRow{}
 	}
-	t.rows[dnsName][e.SetIdentifier].current = e
+	t.rows[dnsName][key].current = e
 }
 
 func (t planTable) addCandidate(e *endpoint.Endpoint) {
 	dnsName := normalizeDNSName(e.DNSName)
+	key := newPlanKey(e)
 	if _, ok := t.rows[dnsName]; !ok {
-		t.rows[dnsName] = make(map[string]*planTableRow)
+		t.rows[dnsName] = make(map[planKey]*planTableRow)
 	}
-	if _, ok := t.rows[dnsName][e.SetIdentifier]; !ok {
-		t.rows[dnsName][e.SetIdentifier] = &planTableRow{}
+	if _, ok := t.rows[dnsName][key]; !ok {
+		t.rows[dnsName][key] = &planTableRow{}
 	}
-	t.rows[dnsName][e.SetIdentifier].candidates = append(t.rows[dnsName][e.SetIdentifier].candidates, e)
+	t.rows[dnsName][key].candidates = append(t.rows[dnsName][key].candidates, e)
 }
 
 func (c *Changes) HasChanges() bool {
-	if len(c.Create) > 0 || len(c.Delete) > 0 {
+	if len(c.Create) > 0 || len(c.Delete) > 0 || len(c.ReplaceNew) > 0 {
 		return true
 	}
 	return !cmp.Equal(c.UpdateNew, c.UpdateOld)
@@ -113,35 +150,52 @@ func (c *Changes) HasChanges() bool {
 // state. It then passes those changes to the current policy for further
 // processing. It returns a copy of Plan with the changes populated.
 func (p *Plan) Calculate() *Plan {
-	t := newPlanTable()
+	t := newPlanTable(p.ConflictResolver)
 
 	if p.DomainFilter == nil {
 		p.DomainFilter = endpoint.MatchAllDomainFilters(nil)
 	}
 
-	for _, current := range filterRecordsForPlan(p.Current, p.DomainFilter, p.ManagedRecords) {
+	currentRecords := filterRecordsForPlan(p.Current, p.DomainFilter, p.ManagedRecords)
+	for _, current := range currentRecords {
 		t.addCurrent(current)
 	}
 	for _, desired := range filterRecordsForPlan(p.Desired, p.DomainFilter, p.ManagedRecords) {
+		if err := desired.NormalizeTargets(); err != nil {
+			log.Errorf("ignoring desired record %s: %v", desired.DNSName, err)
+			continue
+		}
 		t.addCandidate(desired)
 	}
 
-	changes := &Changes{}
+	changes := &Changes{Reasons: Reasons{}}
 
 	for _, topRow := range t.rows {
 		for _, row := range topRow {
 			if row.current == nil { //dns name not taken
-				changes.Create = append(changes.Create, t.resolver.ResolveCreate(row.candidates))
+				create := t.resolver.ResolveCreate(row.candidates)
+				changes.Create = append(changes.Create, create)
+				changes.Reasons.Set(ActionCreate, create, createReason(create, row.candidates))
 			}
 			if row.current != nil && len(row.candidates) == 0 {
 				changes.Delete = append(changes.Delete, row.current)
+				changes.Reasons.Set(ActionDelete, row.current, deleteReason(row.current))
 			}
 
-			// TODO: allows record type change, which might not be supported by all dns providers
 			if row.current != nil && len(row.candidates) > 0 { //dns name is taken
 				update := t.resolver.ResolveUpdate(row.current, row.candidates)
+				// most providers can not change the type of a record in place, so it is
+				// replaced by deleting the current record before creating the desired one
+				if update.RecordType != row.current.RecordType {
+					changes.Reasons.Set(ActionReplace, update, p.updateReason(update, row.current, row.candidates))
+					inheritOwner(row.current, update)
+					changes.ReplaceNew = append(changes.ReplaceNew, update)
+					changes.ReplaceOld = append(changes.ReplaceOld, row.current)
+					continue
+				}
 				// compare "update" to "current" to figure out if actual update is required
 				if shouldUpdateTTL(update, row.current) || targetChanged(update, row.current) || p.shouldUpdateProviderSpecific(update, row.current) {
+					changes.Reasons.Set(ActionUpdate, update, p.updateReason(update, row.current, row.candidates))
 					inheritOwner(row.current, update)
 					changes.UpdateNew = append(changes.UpdateNew, update)
 					changes.UpdateOld = append(changes.UpdateOld, row.current)
@@ -153,12 +207,24 @@ func (p *Plan) Calculate() *Plan {
 	for _, pol := range p.Policies {
 		changes = pol.Apply(changes)
 	}
+	rejected := rejectCNAMEConflicts(changes, p.Current)
+
+	var refused error
+	if p.DeletionGuard != nil {
+		if refused = p.DeletionGuard.Check(changes, currentRecords); refused != nil {
+			changes = &Changes{}
+		}
+	}
 
 	plan := &Plan{
-		Current:        p.Current,
-		Desired:        p.Desired,
-		Changes:        changes,
-		ManagedRecords: []string{endpoint.RecordTypeA, endpoint.RecordTypeCNAME},
+		Current:          p.Current,
+		Desired:          p.Desired,
+		Changes:          changes,
+		ManagedRecords:   []string{endpoint.RecordTypeA, endpoint.RecordTypeCNAME},
+		DeletionGuard:    p.DeletionGuard,
+		ConflictResolver: p.ConflictResolver,
+		Rejected:         rejected,
+		Refused:          refused,
 	}
 
 	return plan
@@ -186,6 +252,13 @@ func shouldUpdateTTL(desired, current *endpoint.Endpoint) bool {
 }
 
 func (p *Plan) shouldUpdateProviderSpecific(desired, current *endpoint.Endpoint) bool {
+	return len(p.changedProviderSpecific(desired, current)) > 0
+}
+
+// changedProviderSpecific returns the names of the provider specific properties of the current
+// record which differ from the desired ones
+func (p *Plan) changedProviderSpecific(desired, current *endpoint.Endpoint) []string {
+	changed := []string{}
 	desiredProperties := map[string]endpoint.ProviderSpecificProperty{}
 
 	if desired.ProviderSpecific != nil {
@@ -198,33 +271,35 @@ func (p *Plan) shouldUpdateProviderSpecific(desired, current *endpoint.Endpoint)
 			if d, ok := desiredProperties[c.Name]; ok {
 				if p.PropertyComparator != nil {
 					if !p.PropertyComparator(c.Name, c.Value, d.Value) {
-						return true
+						changed = append(changed, c.Name)
 					}
 				} else if c.Value != d.Value {
-					return true
+					changed = append(changed, c.Name)
 				}
 			} else {
 				if p.PropertyComparator != nil {
 					if !p.PropertyComparator(c.Name, c.Value, "") {
-						return true
+						changed = append(changed, c.Name)
 					}
 				} else if c.Value != "" {
-					return true
+					changed = append(changed, c.Name)
 				}
 			}
 		}
 	}
 
-	return false
+	return changed
 }
 
 // filterRecordsForPlan removes records that are not relevant to the planner.
-// Currently this just removes TXT records to prevent them from being
-// deleted erroneously by the planner (only the TXT registry should do this.)
+// Records of unmanaged types are removed, as are ownership TXT records to
+// prevent them from being deleted erroneously by the planner (only the TXT
+// registry should do this.) Other TXT records, e.g. SPF or DMARC, are planned
+// like any other record when TXT is a managed record type.
 //
 // Per RFC 1034, CNAME records conflict with all other records - it is the
-// only record with this property. The behavior of the planner may need to be
-// made more sophisticated to codify this.
+// only record with this property. Calculate rejects the changes violating
+// this rule after planning, see rejectCNAMEConflicts.
 func filterRecordsForPlan(records []*endpoint.Endpoint, domainFilter endpoint.DomainFilterInterface, managedRecords []string) []*endpoint.Endpoint {
 	filtered := []*endpoint.Endpoint{}
 
@@ -234,9 +309,14 @@ func filterRecordsForPlan(records []*endpoint.Endpoint, domainFilter endpoint.Do
 			log.Debugf("ignoring record %s that does not match domain filter", record.DNSName)
 			continue
 		}
-		if isManagedRecord(record.RecordType, managedRecords) {
-			filtered = append(filtered, record)
+		if !isManagedRecord(record.RecordType, managedRecords) {
+			continue
 		}
+		if isOwnershipRecord(record) {
+			log.Debugf("ignoring ownership record %s", record.DNSName)
+			continue
+		}
+		filtered = append(filtered, record)
 	}
 
 	return filtered
@@ -284,3 +364,16 @@ func isManagedRecord(record string, managedRecords []string) bool {
 	}
 	return false
 }
+
+// isOwnershipRecord returns true for TXT records holding dops ownership labels
+func isOwnershipRecord(record *endpoint.Endpoint) bool {
+	if record.RecordType != endpoint.RecordTypeTXT {
+		return false
+	}
+	for _, target := range record.Targets {
+		if endpoint.IsOwnershipText(target) {
+			return true
+		}
+	}
+	return false
+}
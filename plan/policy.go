@@ -1,5 +1,16 @@
 package plan
 
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
 // Policy allows to apply different rules to a set of changes.
 type Policy interface {
 	Apply(changes *Changes) *Changes
@@ -26,9 +37,12 @@ type UpsertOnlyPolicy struct{}
 // Apply applies the upsert-only policy which strips out any deletions.
 func (p *UpsertOnlyPolicy) Apply(changes *Changes) *Changes {
 	return &Changes{
-		Create:    changes.Create,
-		UpdateOld: changes.UpdateOld,
-		UpdateNew: changes.UpdateNew,
+		Create:     changes.Create,
+		UpdateOld:  changes.UpdateOld,
+		UpdateNew:  changes.UpdateNew,
+		ReplaceOld: changes.ReplaceOld,
+		ReplaceNew: changes.ReplaceNew,
+		Reasons:    changes.Reasons,
 	}
 }
 
@@ -38,6 +52,213 @@ type CreateOnlyPolicy struct{}
 // Apply applies the create-only policy which strips out updates and deletions.
 func (p *CreateOnlyPolicy) Apply(changes *Changes) *Changes {
 	return &Changes{
-		Create: changes.Create,
+		Create:  changes.Create,
+		Reasons: changes.Reasons,
+	}
+}
+
+// PolicyChain applies several policies one after the other.
+type PolicyChain []Policy
+
+// Apply applies each policy of the chain to the changes left by the previous one.
+func (p PolicyChain) Apply(changes *Changes) *Changes {
+	for _, pol := range p {
+		changes = pol.Apply(changes)
+	}
+	return changes
+}
+
+// DomainPolicy is the policy for the records of a domain and its subdomains.
+type DomainPolicy struct {
+	Domain string
+	Policy Policy
+}
+
+// PerDomainPolicy applies the policy of the most specific matching domain to each change,
+// and the default policy to the changes of records outside all of the domains.
+type PerDomainPolicy struct {
+	Default Policy
+	Domains []DomainPolicy
+}
+
+// Apply splits the changes by domain, applies the domain policies and merges the results.
+func (p *PerDomainPolicy) Apply(changes *Changes) *Changes {
+	split := make([]*Changes, len(p.Domains)+1)
+	for i := range split {
+		split[i] = &Changes{Reasons: changes.Reasons}
+	}
+
+	for _, ep := range changes.Create {
+		c := split[p.domainIndex(ep.DNSName)]
+		c.Create = append(c.Create, ep)
+	}
+	for i, ep := range changes.UpdateNew {
+		c := split[p.domainIndex(ep.DNSName)]
+		c.UpdateOld = append(c.UpdateOld, changes.UpdateOld[i])
+		c.UpdateNew = append(c.UpdateNew, ep)
+	}
+	for i, ep := range changes.ReplaceNew {
+		c := split[p.domainIndex(ep.DNSName)]
+		c.ReplaceOld = append(c.ReplaceOld, changes.ReplaceOld[i])
+		c.ReplaceNew = append(c.ReplaceNew, ep)
+	}
+	for _, ep := range changes.Delete {
+		c := split[p.domainIndex(ep.DNSName)]
+		c.Delete = append(c.Delete, ep)
+	}
+
+	merged := &Changes{Reasons: changes.Reasons}
+	for i, c := range split {
+		pol := p.Default
+		if i < len(p.Domains) {
+			pol = p.Domains[i].Policy
+		}
+		c = pol.Apply(c)
+		merged.Create = append(merged.Create, c.Create...)
+		merged.UpdateOld = append(merged.UpdateOld, c.UpdateOld...)
+		merged.UpdateNew = append(merged.UpdateNew, c.UpdateNew...)
+		merged.ReplaceOld = append(merged.ReplaceOld, c.ReplaceOld...)
+		merged.ReplaceNew = append(merged.ReplaceNew, c.ReplaceNew...)
+		merged.Delete = append(merged.Delete, c.Delete...)
+	}
+	return merged
+}
+
+// domainIndex returns the index of the most specific domain matching the name,
+// or len(p.Domains) if none matches
+func (p *PerDomainPolicy) domainIndex(dnsName string) int {
+	name := strings.TrimSuffix(normalizeDNSName(dnsName), ".")
+	index, longest := len(p.Domains), -1
+	for i, d := range p.Domains {
+		domain := strings.Trim(strings.ToLower(d.Domain), ".")
+		if (name == domain || strings.HasSuffix(name, "."+domain)) && len(domain) > longest {
+			index, longest = i, len(domain)
+		}
+	}
+	return index
+}
+
+// NamePattern matches DNS names against a glob, e.g. *.pay.example.com, or against
+// a regular expression if the pattern has the "regex:" prefix
+type NamePattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+// NewNamePattern parses a glob or regular expression pattern
+func NewNamePattern(pattern string) (NamePattern, error) {
+	if expr := strings.TrimPrefix(pattern, "regex:"); expr != pattern {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return NamePattern{}, fmt.Errorf("invalid name pattern %q: %v", pattern, err)
+		}
+		return NamePattern{regex: regex}, nil
+	}
+	glob := strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if _, err := path.Match(glob, ""); err != nil {
+		return NamePattern{}, fmt.Errorf("invalid name pattern %q: %v", pattern, err)
+	}
+	return NamePattern{glob: glob}, nil
+}
+
+// Match returns true if the DNS name matches the pattern
+func (n NamePattern) Match(dnsName string) bool {
+	name := strings.TrimSuffix(normalizeDNSName(dnsName), ".")
+	if n.regex != nil {
+		return n.regex.MatchString(name)
+	}
+	ok, _ := path.Match(n.glob, name)
+	return ok
+}
+
+func (n NamePattern) String() string {
+	if n.regex != nil {
+		return "regex:" + n.regex.String()
+	}
+	return n.glob
+}
+
+// ProtectedNamesPolicy never updates, replaces or deletes records whose name matches one of the patterns.
+type ProtectedNamesPolicy struct {
+	Patterns []NamePattern
+}
+
+// Apply strips out the updates, replacements and deletions of protected records.
+func (p *ProtectedNamesPolicy) Apply(changes *Changes) *Changes {
+	filtered := &Changes{
+		Create:  changes.Create,
+		Reasons: changes.Reasons,
+	}
+	for i, ep := range changes.UpdateNew {
+		if p.protects(ActionUpdate, changes.UpdateOld[i]) {
+			continue
+		}
+		filtered.UpdateOld = append(filtered.UpdateOld, changes.UpdateOld[i])
+		filtered.UpdateNew = append(filtered.UpdateNew, ep)
+	}
+	for i, ep := range changes.ReplaceNew {
+		if p.protects(ActionReplace, changes.ReplaceOld[i]) {
+			continue
+		}
+		filtered.ReplaceOld = append(filtered.ReplaceOld, changes.ReplaceOld[i])
+		filtered.ReplaceNew = append(filtered.ReplaceNew, ep)
+	}
+	for _, ep := range changes.Delete {
+		if p.protects(ActionDelete, ep) {
+			continue
+		}
+		filtered.Delete = append(filtered.Delete, ep)
+	}
+	return filtered
+}
+
+func (p *ProtectedNamesPolicy) protects(action string, current *endpoint.Endpoint) bool {
+	for _, pattern := range p.Patterns {
+		if pattern.Match(current.DNSName) {
+			log.WithFields(log.Fields{
+				"record":  current.DNSName,
+				"type":    current.RecordType,
+				"action":  action,
+				"pattern": pattern.String(),
+			}).Warn("Skipping change of protected record")
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyPolicy applies the policy to changes which were not calculated by a plan, e.g. the
+// changes of a rollback, and logs the changes the policy filtered out
+func ApplyPolicy(policy Policy, changes *Changes) *Changes {
+	filtered := policy.Apply(changes)
+	kept := map[*endpoint.Endpoint]bool{}
+	for _, eps := range [][]*endpoint.Endpoint{filtered.Create, filtered.UpdateNew, filtered.ReplaceNew, filtered.Delete} {
+		for _, ep := range eps {
+			kept[ep] = true
+		}
+	}
+	skipped := func(action string, ep *endpoint.Endpoint) {
+		if kept[ep] {
+			return
+		}
+		log.WithFields(log.Fields{
+			"record": ep.DNSName,
+			"type":   ep.RecordType,
+			"setID":  ep.SetIdentifier,
+			"action": action,
+		}).Info("Skipping change not allowed by the policy")
+	}
+	for _, ep := range changes.Create {
+		skipped(ActionCreate, ep)
+	}
+	for _, ep := range changes.UpdateNew {
+		skipped(ActionUpdate, ep)
+	}
+	for _, ep := range changes.ReplaceNew {
+		skipped(ActionReplace, ep)
+	}
+	for _, ep := range changes.Delete {
+		skipped(ActionDelete, ep)
 	}
+	return filtered
 }
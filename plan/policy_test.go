@@ -0,0 +1,46 @@
+package plan
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+func TestApplyPolicyLogsSkippedChanges(t *testing.T) {
+	pattern, err := NewNamePattern("*.pay.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := PolicyChain{&PerDomainPolicy{
+		Default: &SyncPolicy{},
+		Domains: []DomainPolicy{{Domain: "example.org", Policy: &UpsertOnlyPolicy{}}},
+	}, &ProtectedNamesPolicy{Patterns: []NamePattern{pattern}}}
+
+	created := endpoint.NewEndpoint("new.example.com", endpoint.RecordTypeA, "192.0.2.1")
+	protected := endpoint.NewEndpoint("api.pay.example.com", endpoint.RecordTypeA, "192.0.2.1")
+	upsertOnly := endpoint.NewEndpoint("foo.example.org", endpoint.RecordTypeA, "192.0.2.1")
+	deleted := endpoint.NewEndpoint("old.example.com", endpoint.RecordTypeA, "192.0.2.1")
+
+	hook := test.NewGlobal()
+	log.SetLevel(log.InfoLevel)
+	filtered := ApplyPolicy(policy, &Changes{
+		Create: []*endpoint.Endpoint{created},
+		Delete: []*endpoint.Endpoint{protected, upsertOnly, deleted},
+	})
+
+	if len(filtered.Create) != 1 || len(filtered.Delete) != 1 || filtered.Delete[0] != deleted {
+		t.Errorf("expected the creation and the deletion of old.example.com, got %v", filtered)
+	}
+	skipped := map[string]bool{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Skipping change not allowed by the policy" {
+			skipped[entry.Data["record"].(string)] = true
+		}
+	}
+	if len(skipped) != 2 || !skipped["api.pay.example.com"] || !skipped["foo.example.org"] {
+		t.Errorf("expected the skipped deletions to be logged, got %v", skipped)
+	}
+}
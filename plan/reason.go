@@ -0,0 +1,84 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// Reasons explains why the planner emitted each change, keyed by the action and the record
+type Reasons map[string]string
+
+func reasonKey(action string, ep *endpoint.Endpoint) string {
+	return action + " " + recordID(ep)
+}
+
+// Set records the reason of a change, ep is the desired record for creations and
+// updates and the current record for deletions
+func (r Reasons) Set(action string, ep *endpoint.Endpoint, reason string) {
+	r[reasonKey(action, ep)] = reason
+}
+
+// Get returns the reason of a change, or an empty string if it is unknown
+func (r Reasons) Get(action string, ep *endpoint.Endpoint) string {
+	return r[reasonKey(action, ep)]
+}
+
+// Reason returns the reason of a change, see Reasons.Set
+func (c *Changes) Reason(action string, ep *endpoint.Endpoint) string {
+	return c.Reasons.Get(action, ep)
+}
+
+func createReason(desired *endpoint.Endpoint, candidates []*endpoint.Endpoint) string {
+	return joinReasons([]string{"not in current records"}, conflictReason(desired, candidates))
+}
+
+func deleteReason(current *endpoint.Endpoint) string {
+	if owner := current.Labels[endpoint.OwnerLabelKey]; owner != "" {
+		return fmt.Sprintf("not in desired and owned by %s", owner)
+	}
+	return "not in desired"
+}
+
+func (p *Plan) updateReason(desired, current *endpoint.Endpoint, candidates []*endpoint.Endpoint) string {
+	reasons := []string{}
+	if desired.RecordType != current.RecordType {
+		reasons = append(reasons, fmt.Sprintf("record type changed %s→%s", current.RecordType, desired.RecordType))
+	}
+	if targetChanged(desired, current) {
+		reasons = append(reasons, fmt.Sprintf("target changed %s→%s", current.Targets, desired.Targets))
+	}
+	if shouldUpdateTTL(desired, current) {
+		reasons = append(reasons, fmt.Sprintf("TTL changed %d→%d", current.RecordTTL, desired.RecordTTL))
+	}
+	for _, name := range p.changedProviderSpecific(desired, current) {
+		reasons = append(reasons, fmt.Sprintf("provider-specific %s changed", name))
+	}
+	return joinReasons(reasons, conflictReason(desired, candidates))
+}
+
+// conflictReason names the resources the desired record won against, if any
+func conflictReason(desired *endpoint.Endpoint, candidates []*endpoint.Endpoint) string {
+	if len(candidates) < 2 {
+		return ""
+	}
+	winner := desired.Labels[endpoint.ResourceLabelKey]
+	losers := []string{}
+	for _, ep := range candidates {
+		if ep != desired && ep.Labels[endpoint.ResourceLabelKey] != winner {
+			losers = append(losers, describeCandidate(ep))
+		}
+	}
+	if len(losers) == 0 {
+		return ""
+	}
+	return "conflict resolved against " + strings.Join(losers, ", ")
+}
+
+func joinReasons(reasons []string, conflict string) string {
+	if conflict != "" {
+		reasons = append(reasons, conflict)
+	}
+	return strings.Join(reasons, "; ")
+}
@@ -0,0 +1,131 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// savedPlanVersion is the version of the saved plan format
+const savedPlanVersion = 1
+
+// SavedPlan is a plan written to a file, to be reviewed and applied later.
+// It holds the records the changes were calculated against, so that applying
+// it can detect when the records changed in the meantime.
+type SavedPlan struct {
+	Version   int                  `json:"version"`
+	CreatedAt time.Time            `json:"createdAt"`
+	Current   []*endpoint.Endpoint `json:"current"`
+	Changes   *Changes             `json:"changes"`
+}
+
+// StalePlanError is returned when the records changed since a saved plan was calculated
+type StalePlanError struct {
+	// Drift holds the changes from the records the plan was calculated against to the current records
+	Drift *Changes
+}
+
+func (e *StalePlanError) Error() string {
+	return fmt.Sprintf("plan is stale, %d record(s) changed since it was calculated", len(e.Drift.Create)+len(e.Drift.UpdateNew)+len(e.Drift.Delete))
+}
+
+// Save writes the changes of the plan and the records they were calculated against to a file
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(SavedPlan{
+		Version:   savedPlanVersion,
+		CreatedAt: time.Now().UTC(),
+		Current:   p.Current,
+		Changes:   p.Changes,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadSavedPlan reads a plan written by Save
+func LoadSavedPlan(path string) (*SavedPlan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &SavedPlan{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse plan file %s: %v", path, err)
+	}
+	if s.Version != savedPlanVersion {
+		return nil, fmt.Errorf("unsupported version %d of plan file %s", s.Version, path)
+	}
+	if s.Changes == nil {
+		s.Changes = &Changes{}
+	}
+	return s, nil
+}
+
+// CheckCurrent returns a StalePlanError if the current records differ from the ones the plan was calculated against
+func (s *SavedPlan) CheckCurrent(current []*endpoint.Endpoint) error {
+	drift := Drift(s.Current, current)
+	if len(drift.Create)+len(drift.UpdateNew)+len(drift.Delete) > 0 {
+		return &StalePlanError{Drift: drift}
+	}
+	return nil
+}
+
+// Drift returns the records which were added, changed or removed between two listings of the same records
+func Drift(before, after []*endpoint.Endpoint) *Changes {
+	type key struct {
+		dnsName, recordType, setIdentifier string
+	}
+	keyOf := func(e *endpoint.Endpoint) key {
+		return key{normalizeDNSName(e.DNSName), e.RecordType, e.SetIdentifier}
+	}
+
+	previous := map[key]*endpoint.Endpoint{}
+	for _, e := range before {
+		previous[keyOf(e)] = e
+	}
+
+	drift := &Changes{}
+	for _, e := range after {
+		k := keyOf(e)
+		old, ok := previous[k]
+		delete(previous, k)
+		if !ok {
+			drift.Create = append(drift.Create, e)
+			continue
+		}
+		if !sameRecord(old, e) {
+			drift.UpdateOld = append(drift.UpdateOld, old)
+			drift.UpdateNew = append(drift.UpdateNew, e)
+		}
+	}
+	for _, e := range previous {
+		drift.Delete = append(drift.Delete, e)
+	}
+	sort.Slice(drift.Delete, func(i, j int) bool {
+		return drift.Delete[i].DNSName < drift.Delete[j].DNSName
+	})
+	return drift
+}
+
+func sameRecord(a, b *endpoint.Endpoint) bool {
+	if a.RecordTTL != b.RecordTTL || !a.Targets.Same(b.Targets) {
+		return false
+	}
+	if len(a.Labels) != len(b.Labels) || (len(a.Labels) > 0 && !reflect.DeepEqual(a.Labels, b.Labels)) {
+		return false
+	}
+	aProps, bProps := map[string]string{}, map[string]string{}
+	for _, p := range a.ProviderSpecific {
+		aProps[p.Name] = p.Value
+	}
+	for _, p := range b.ProviderSpecific {
+		bProps[p.Name] = p.Value
+	}
+	return reflect.DeepEqual(aProps, bProps)
+}
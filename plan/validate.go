@@ -0,0 +1,112 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// ErrCNAMEConflict is returned for a change which would leave a CNAME record next to records of other types
+var ErrCNAMEConflict = errors.New("CNAME record conflicts with records of other types")
+
+// Rejection is a desired record the planner refused to apply
+type Rejection struct {
+	Endpoint *endpoint.Endpoint
+	Err      error
+}
+
+// rejectCNAMEConflicts drops the creations and replacements which would leave a CNAME record
+// next to records of other types, see RFC 1034 section 3.6.2. Providers fail the whole batch
+// of changes on such a conflict, so only the offending changes are dropped. A desired CNAME
+// conflicting with another record is always the one rejected, a desired record of another type
+// is rejected only if the conflicting CNAME record already exists.
+func rejectCNAMEConflicts(changes *Changes, current []*endpoint.Endpoint) []Rejection {
+	type nameTypes map[string]map[string]bool
+	add := func(m nameTypes, ep *endpoint.Endpoint) {
+		name := normalizeDNSName(ep.DNSName)
+		if m[name] == nil {
+			m[name] = map[string]bool{}
+		}
+		m[name][ep.RecordType] = true
+	}
+
+	// the records which remain once the deletions are applied
+	removed := map[string]bool{}
+	for _, ep := range append(append([]*endpoint.Endpoint{}, changes.Delete...), changes.ReplaceOld...) {
+		removed[recordID(ep)] = true
+	}
+	remaining := nameTypes{}
+	for _, ep := range current {
+		if !removed[recordID(ep)] && !isOwnershipRecord(ep) {
+			add(remaining, ep)
+		}
+	}
+	added := nameTypes{}
+	for _, ep := range append(append([]*endpoint.Endpoint{}, changes.Create...), changes.ReplaceNew...) {
+		add(added, ep)
+	}
+
+	conflict := func(ep *endpoint.Endpoint) error {
+		name := normalizeDNSName(ep.DNSName)
+		if ep.RecordType == endpoint.RecordTypeCNAME {
+			others := []string{}
+			for _, types := range []map[string]bool{remaining[name], added[name]} {
+				for t := range types {
+					if t != endpoint.RecordTypeCNAME {
+						others = append(others, t)
+					}
+				}
+			}
+			if len(others) == 0 {
+				return nil
+			}
+			sort.Strings(others)
+			return fmt.Errorf("%w: %s already has %s record(s)", ErrCNAMEConflict, ep.DNSName, strings.Join(others, ", "))
+		}
+		if remaining[name][endpoint.RecordTypeCNAME] {
+			return fmt.Errorf("%w: %s already has a CNAME record, refusing to add a %s record", ErrCNAMEConflict, ep.DNSName, ep.RecordType)
+		}
+		return nil
+	}
+
+	rejections := []Rejection{}
+	reject := func(ep *endpoint.Endpoint, err error) {
+		log.WithFields(log.Fields{
+			"record": ep.DNSName,
+			"type":   ep.RecordType,
+			"setID":  ep.SetIdentifier,
+		}).Errorf("Rejecting desired record: %v", err)
+		rejections = append(rejections, Rejection{Endpoint: ep, Err: err})
+	}
+
+	creates := []*endpoint.Endpoint{}
+	for _, ep := range changes.Create {
+		if err := conflict(ep); err != nil {
+			reject(ep, err)
+			continue
+		}
+		creates = append(creates, ep)
+	}
+	replaceOld, replaceNew := []*endpoint.Endpoint{}, []*endpoint.Endpoint{}
+	for i, ep := range changes.ReplaceNew {
+		if err := conflict(ep); err != nil {
+			reject(ep, err)
+			continue
+		}
+		replaceOld = append(replaceOld, changes.ReplaceOld[i])
+		replaceNew = append(replaceNew, ep)
+	}
+	changes.Create, changes.ReplaceOld, changes.ReplaceNew = creates, replaceOld, replaceNew
+
+	return rejections
+}
+
+// recordID identifies a record by name, type and set identifier
+func recordID(ep *endpoint.Endpoint) string {
+	return strings.Join([]string{normalizeDNSName(ep.DNSName), ep.RecordType, ep.SetIdentifier}, " ")
+}
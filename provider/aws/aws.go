@@ -31,6 +31,8 @@ const (
 	// As we are using the standard AWS client, this should already be compliant.
 	// Hence, ifever AWS decides to raise this limit, we will automatically reduce the pressure on rate limits
 	route53PageSize = "300"
+	// maximum length of a single character string within a TXT record value
+	txtCharacterStringLength = 255
 	// provider specific key that designates whether an AWS ALIAS record has the EvaluateTargetHealth
 	// field set to true.
 	providerSpecificAlias                      = "alias"
@@ -279,6 +281,49 @@ func wildcardUnescape(s string) string {
 	return s
 }
 
+// quoteTXT converts a TXT value to the quoted format expected by Route53, splitting
+// it into character strings of at most 255 characters. Values which are already
+// quoted, like the ones of ownership records, are returned as is.
+func quoteTXT(value string) string {
+	if strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") && len(value) > 1 {
+		return value
+	}
+	var chunks []string
+	for len(value) > txtCharacterStringLength {
+		chunks = append(chunks, value[:txtCharacterStringLength])
+		value = value[txtCharacterStringLength:]
+	}
+	chunks = append(chunks, value)
+	for i, chunk := range chunks {
+		chunk = strings.ReplaceAll(chunk, "\\", "\\\\")
+		chunks[i] = "\"" + strings.ReplaceAll(chunk, "\"", "\\\"") + "\""
+	}
+	return strings.Join(chunks, " ")
+}
+
+// unquoteTXT joins the quoted character strings of a Route53 TXT value into a single value.
+func unquoteTXT(value string) string {
+	if !strings.HasPrefix(value, "\"") {
+		return value
+	}
+	var sb strings.Builder
+	quoted, escaped := false, false
+	for _, c := range value {
+		switch {
+		case escaped:
+			sb.WriteRune(c)
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
 // Records returns the list of records in a given hosted zone.
 func (p *AWSProvider) Records(ctx context.Context) (endpoints []*endpoint.Endpoint, _ error) {
 	zones, err := p.Zones(ctx)
@@ -311,11 +356,20 @@ func (p *AWSProvider) records(ctx context.Context, zones map[string]*route53.Hos
 				targets := make([]string, len(r.ResourceRecords))
 				for idx, rr := range r.ResourceRecords {
 					targets[idx] = aws.StringValue(rr.Value)
+					if aws.StringValue(r.Type) == endpoint.RecordTypeTXT {
+						targets[idx] = unquoteTXT(targets[idx])
+					}
 				}
 
 				newEndpoints = append(newEndpoints, endpoint.NewEndpointWithTTL(wildcardUnescape(aws.StringValue(r.Name)), aws.StringValue(r.Type), ttl, targets...))
 			}
 
+			// AAAA alias records are the dualstack counterpart of an A alias record, which is
+			// already represented by the CNAME endpoint below
+			if r.AliasTarget != nil && aws.StringValue(r.Type) == endpoint.RecordTypeAAAA {
+				continue
+			}
+
 			if r.AliasTarget != nil {
 				// Alias records don't have TTLs so provide the default to match the TXT generation
 				if ttl == 0 {
@@ -462,10 +516,14 @@ func (p *AWSProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) e
 
 	updateChanges := p.createUpdateChanges(changes.UpdateNew, changes.UpdateOld)
 
-	combinedChanges := make([]*route53.Change, 0, len(changes.Delete)+len(changes.Create)+len(updateChanges))
+	combinedChanges := make([]*route53.Change, 0, len(changes.Delete)+len(changes.Create)+len(updateChanges)+2*len(changes.ReplaceNew))
 	combinedChanges = append(combinedChanges, p.newChanges(route53.ChangeActionCreate, changes.Create)...)
 	combinedChanges = append(combinedChanges, p.newChanges(route53.ChangeActionDelete, changes.Delete)...)
 	combinedChanges = append(combinedChanges, updateChanges...)
+	// a batch holds all changes of a name and is applied atomically, so a record changing
+	// type is deleted and created again without a gap
+	combinedChanges = append(combinedChanges, p.newChanges(route53.ChangeActionDelete, changes.ReplaceOld)...)
+	combinedChanges = append(combinedChanges, p.newChanges(route53.ChangeActionCreate, changes.ReplaceNew)...)
 
 	return p.submitChanges(ctx, combinedChanges, zones)
 }
@@ -615,6 +673,9 @@ func (p *AWSProvider) newChange(action string, ep *endpoint.Endpoint) (*route53.
 		}
 		change.ResourceRecordSet.ResourceRecords = make([]*route53.ResourceRecord, len(ep.Targets))
 		for idx, val := range ep.Targets {
+			if ep.RecordType == endpoint.RecordTypeTXT {
+				val = quoteTXT(val)
+			}
 			change.ResourceRecordSet.ResourceRecords[idx] = &route53.ResourceRecord{
 				Value: aws.String(val),
 			}
@@ -0,0 +1,164 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	cf "github.com/cloudflare/cloudflare-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// permissions reported by the API for the zones the credentials can access
+	permissionDNSRead = "#dns_records:read"
+	permissionDNSEdit = "#dns_records:edit"
+)
+
+// zoneLister lists the zones visible to a client, limited to a single account or zone
+// if the client was configured for one
+type zoneLister struct {
+	client    cloudFlareDNS
+	accountID string
+	zone      string
+}
+
+// zoneListers returns a lister for the default client and for each account and zone client
+func (p *CloudFlareProvider) zoneListers() []zoneLister {
+	listers := []zoneLister{}
+	if p.Client != nil {
+		listers = append(listers, zoneLister{client: p.Client})
+	}
+	for _, account := range sortedKeys(p.accountClients) {
+		listers = append(listers, zoneLister{client: p.accountClients[account], accountID: account})
+	}
+	for _, zone := range sortedKeys(p.zoneClients) {
+		listers = append(listers, zoneLister{client: p.zoneClients[zone], zone: zone})
+	}
+	return listers
+}
+
+// zoneDetails looks up a zone by ID, trying the zone client first and then the others
+func (p *CloudFlareProvider) zoneDetails(zoneID string) (cf.Zone, error) {
+	clients := []cloudFlareDNS{}
+	if client, ok := p.zoneClients[zoneID]; ok {
+		clients = append(clients, client)
+	}
+	if p.Client != nil {
+		clients = append(clients, p.Client)
+	}
+	for _, account := range sortedKeys(p.accountClients) {
+		clients = append(clients, p.accountClients[account])
+	}
+
+	err := fmt.Errorf("no credentials configured")
+	for _, client := range clients {
+		var zone cf.Zone
+		if zone, err = client.ZoneDetails(zoneID); err == nil {
+			return zone, nil
+		}
+	}
+	return cf.Zone{}, err
+}
+
+// matchAccount returns true if the zone belongs to one of the accounts of the account ID filter
+func (p *CloudFlareProvider) matchAccount(zone cf.Zone) bool {
+	return len(p.accountIDFilter) == 0 || p.accountIDFilter[zone.Account.ID]
+}
+
+// clientForZone returns the client with the most specific credentials for the zone
+func (p *CloudFlareProvider) clientForZone(zone cf.Zone) cloudFlareDNS {
+	if client, ok := p.zoneClients[zone.ID]; ok {
+		return client
+	}
+	if client, ok := p.zoneClients[zone.Name]; ok {
+		return client
+	}
+	if client, ok := p.accountClients[zone.Account.ID]; ok {
+		return client
+	}
+	return p.Client
+}
+
+func (p *CloudFlareProvider) setZoneClients(clientsByZoneID map[string]cloudFlareDNS) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clientsByZoneID = clientsByZoneID
+}
+
+// clientForZoneID returns the client for a zone returned by the last call to Zones
+func (p *CloudFlareProvider) clientForZoneID(zoneID string) (cloudFlareDNS, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if client, ok := p.clientsByZoneID[zoneID]; ok && client != nil {
+		return client, nil
+	}
+	if p.Client != nil {
+		return p.Client, nil
+	}
+	return nil, fmt.Errorf("no credentials configured for zone %s", zoneID)
+}
+
+// CheckPermissions reports which of the zones managed by the provider the configured
+// credentials can read and write. It returns an error if no zone can be read or written.
+func (p *CloudFlareProvider) CheckPermissions(ctx context.Context) error {
+	zones, err := p.Zones(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list cloudflare zones: %v", err)
+	}
+
+	readable, writable := 0, 0
+	for _, zone := range zones {
+		fields := log.Fields{
+			"zone":    zone.Name,
+			"zoneID":  zone.ID,
+			"account": zone.Account.ID,
+		}
+		if len(zone.Permissions) == 0 {
+			log.WithFields(fields).Warn("Unable to determine the permissions of the credentials for this zone")
+			continue
+		}
+
+		permissions := map[string]bool{}
+		for _, permission := range zone.Permissions {
+			permissions[permission] = true
+		}
+		canWrite := permissions[permissionDNSEdit]
+		canRead := canWrite || permissions[permissionDNSRead]
+		fields["read"] = canRead
+		fields["write"] = canWrite
+
+		switch {
+		case canWrite:
+			readable++
+			writable++
+			log.WithFields(fields).Info("Zone is readable and writable")
+		case canRead:
+			readable++
+			if p.DryRun {
+				log.WithFields(fields).Info("Zone is readable")
+			} else {
+				log.WithFields(fields).Warn("Zone is readable but not writable, changes to its records will fail")
+			}
+		default:
+			log.WithFields(fields).Warn("Zone is neither readable nor writable")
+		}
+	}
+
+	if len(zones) > 0 && readable == 0 {
+		return fmt.Errorf("none of the %d cloudflare zones is readable", len(zones))
+	}
+	if readable > 0 && writable == 0 && !p.DryRun {
+		return fmt.Errorf("none of the %d readable cloudflare zones is writable", readable)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]cloudFlareDNS) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,10 +2,14 @@ package cloudflare
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
+	"math"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	cf "github.com/cloudflare/cloudflare-go"
 	log "github.com/sirupsen/logrus"
@@ -23,15 +27,18 @@ const (
 	cloudFlareUpdate = "UPDATE"
 	// automatic
 	defaultCloudFlareRecordTTL = 1
+	// cloudFlareRecordsPerPage is the maximum number of records the API returns per page
+	cloudFlareRecordsPerPage = 100
+	// range of TTLs accepted for records that are not automatic
+	minCloudFlareRecordTTL = 60
+	maxCloudFlareRecordTTL = 86400
 )
 
-var cloudFlareTypeNotSupported = map[string]bool{
-	"LOC": true,
-	"MX":  true,
-	"NS":  true,
-	"SPF": true,
-	"TXT": true,
-	"SRV": true,
+// cloudFlareProxiableTypes are the record types whose traffic can go through Cloudflare
+var cloudFlareProxiableTypes = map[string]bool{
+	endpoint.RecordTypeA:     true,
+	endpoint.RecordTypeAAAA:  true,
+	endpoint.RecordTypeCNAME: true,
 }
 
 // cloudFlareDNS is the subset of the CloudFlare API. Add methods as required. Signatures must match exactly.
@@ -42,9 +49,25 @@ type cloudFlareDNS interface {
 	ListZonesContext(ctx context.Context, opts ...cf.ReqOption) (cf.ZonesResponse, error)
 	ZoneDetails(zoneID string) (cf.Zone, error)
 	DNSRecords(zoneID string, rr cf.DNSRecord) ([]cf.DNSRecord, error)
+	DNSRecordsPage(zoneID string, page, perPage int) ([]cf.DNSRecord, error)
 	CreateDNSRecord(zoneID string, rr cf.DNSRecord) (*cf.DNSRecordResponse, error)
 	DeleteDNSRecord(zoneID, recordID string) error
 	UpdateDNSRecord(zoneID, recordID string, rr cf.DNSRecord) error
+	CreateDNSRecordWithComment(zoneID string, rr cf.DNSRecord, comment string) error
+	UpdateDNSRecordWithComment(zoneID, recordID string, rr cf.DNSRecord, comment string) error
+	ListLoadBalancers(zoneID string) ([]cf.LoadBalancer, error)
+	CreateLoadBalancer(zoneID string, lb cf.LoadBalancer) (cf.LoadBalancer, error)
+	ModifyLoadBalancer(zoneID string, lb cf.LoadBalancer) (cf.LoadBalancer, error)
+	DeleteLoadBalancer(zoneID, lbID string) error
+	ListLoadBalancerPools() ([]cf.LoadBalancerPool, error)
+	CreateLoadBalancerPool(pool cf.LoadBalancerPool) (cf.LoadBalancerPool, error)
+	ModifyLoadBalancerPool(pool cf.LoadBalancerPool) (cf.LoadBalancerPool, error)
+}
+
+// dnsRecordWithComment adds the record comment, which cf.DNSRecord does not support, to a record
+type dnsRecordWithComment struct {
+	cf.DNSRecord
+	Comment string `json:"comment"`
 }
 
 type zoneService struct {
@@ -70,6 +93,18 @@ func (z zoneService) CreateDNSRecord(zoneID string, rr cf.DNSRecord) (*cf.DNSRec
 func (z zoneService) DNSRecords(zoneID string, rr cf.DNSRecord) ([]cf.DNSRecord, error) {
 	return z.service.DNSRecords(zoneID, rr)
 }
+func (z zoneService) DNSRecordsPage(zoneID string, page, perPage int) ([]cf.DNSRecord, error) {
+	result, err := z.service.Raw("GET", fmt.Sprintf("/zones/%s/dns_records?page=%d&per_page=%d", zoneID, page, perPage), nil)
+	if err != nil {
+		return nil, err
+	}
+	records := []cf.DNSRecord{}
+	if err := json.Unmarshal(result, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
 func (z zoneService) UpdateDNSRecord(zoneID, recordID string, rr cf.DNSRecord) error {
 	return z.service.UpdateDNSRecord(zoneID, recordID, rr)
 }
@@ -77,6 +112,44 @@ func (z zoneService) DeleteDNSRecord(zoneID, recordID string) error {
 	return z.service.DeleteDNSRecord(zoneID, recordID)
 }
 
+func (z zoneService) CreateDNSRecordWithComment(zoneID string, rr cf.DNSRecord, comment string) error {
+	_, err := z.service.Raw("POST", "/zones/"+zoneID+"/dns_records", dnsRecordWithComment{rr, comment})
+	return err
+}
+
+func (z zoneService) UpdateDNSRecordWithComment(zoneID, recordID string, rr cf.DNSRecord, comment string) error {
+	_, err := z.service.Raw("PATCH", "/zones/"+zoneID+"/dns_records/"+recordID, dnsRecordWithComment{rr, comment})
+	return err
+}
+
+func (z zoneService) ListLoadBalancers(zoneID string) ([]cf.LoadBalancer, error) {
+	return z.service.ListLoadBalancers(zoneID)
+}
+
+func (z zoneService) CreateLoadBalancer(zoneID string, lb cf.LoadBalancer) (cf.LoadBalancer, error) {
+	return z.service.CreateLoadBalancer(zoneID, lb)
+}
+
+func (z zoneService) ModifyLoadBalancer(zoneID string, lb cf.LoadBalancer) (cf.LoadBalancer, error) {
+	return z.service.ModifyLoadBalancer(zoneID, lb)
+}
+
+func (z zoneService) DeleteLoadBalancer(zoneID, lbID string) error {
+	return z.service.DeleteLoadBalancer(zoneID, lbID)
+}
+
+func (z zoneService) ListLoadBalancerPools() ([]cf.LoadBalancerPool, error) {
+	return z.service.ListLoadBalancerPools()
+}
+
+func (z zoneService) CreateLoadBalancerPool(pool cf.LoadBalancerPool) (cf.LoadBalancerPool, error) {
+	return z.service.CreateLoadBalancerPool(pool)
+}
+
+func (z zoneService) ModifyLoadBalancerPool(pool cf.LoadBalancerPool) (cf.LoadBalancerPool, error) {
+	return z.service.ModifyLoadBalancerPool(pool)
+}
+
 func (z zoneService) ListZonesContext(ctx context.Context, opts ...cf.ReqOption) (cf.ZonesResponse, error) {
 	return z.service.ListZonesContext(ctx, opts...)
 }
@@ -88,52 +161,149 @@ func (z zoneService) ZoneDetails(zoneID string) (cf.Zone, error) {
 // CloudFlareProvider is an implementation of Provider for CloudFlare DNS.
 type CloudFlareProvider struct {
 	provider.BaseProvider
+	// Client is used for the zones without account or zone specific credentials, it may be nil
 	Client cloudFlareDNS
+	// accountClients and zoneClients hold the clients created from the credentials file,
+	// keyed by account ID and by zone ID or name
+	accountClients map[string]cloudFlareDNS
+	zoneClients    map[string]cloudFlareDNS
+	// accountIDFilter only considers zones of these accounts, if not empty
+	accountIDFilter map[string]bool
 	// only consider hosted zones managing domains ending in this suffix
 	domainFilter      endpoint.DomainFilter
 	zoneIDFilter      provider.ZoneIDFilter
 	proxiedByDefault  bool
 	DryRun            bool
 	PaginationOptions cf.PaginationOptions
+	// zoneConcurrency is the number of zones read or changed in parallel
+	zoneConcurrency int
+
+	// clientsByZoneID holds the client used for each zone returned by the last call to Zones
+	mu              sync.Mutex
+	clientsByZoneID map[string]cloudFlareDNS
+}
+
+// CloudFlareConfig contains configuration to create a new Cloudflare provider.
+type CloudFlareConfig struct {
+	DomainFilter     endpoint.DomainFilter
+	ZoneIDFilter     provider.ZoneIDFilter
+	ZonesPerPage     int
+	ProxiedByDefault bool
+	DryRun           bool
+	// RequestsPerSecond limits the rate of calls to the Cloudflare API
+	RequestsPerSecond float64
+	// APIRetries is the number of times a throttled or failed call is retried
+	APIRetries int
+	// ZoneConcurrency is the number of zones read or changed in parallel
+	ZoneConcurrency int
+	// AccountIDs only considers zones of these accounts, if not empty
+	AccountIDs []string
+	// CredentialsFile maps accounts and zones to the credentials used to manage them
+	CredentialsFile string
 }
 
 // cloudFlareChange differentiates between ChangeActions
 type cloudFlareChange struct {
 	Action         string
 	ResourceRecord cf.DNSRecord
+	// Comment is shown next to the record in the Cloudflare dashboard
+	Comment string
+	// LoadBalancer is set instead of the record for endpoints served by a load balancer
+	LoadBalancer *loadBalancer
 }
 
-func NewCloudFlareProvider(domainFilter endpoint.DomainFilter, zoneIDFilter provider.ZoneIDFilter, zonesPerPage int, proxiedByDefault bool, dryRun bool) (*CloudFlareProvider, error) {
-	// initialize via chosen auth method and return new API object
-	var (
-		config *cf.API
-		err    error
-	)
-	if os.Getenv("CF_API_TOKEN") != "" {
-		config, err = cf.NewWithAPIToken(os.Getenv("CF_API_TOKEN"))
-	} else {
-		config, err = cf.New(os.Getenv("CF_API_KEY"), os.Getenv("CF_API_EMAIL"))
+// NewCloudFlareProvider initializes a new Cloudflare based Provider.
+func NewCloudFlareProvider(cfConfig CloudFlareConfig) (*CloudFlareProvider, error) {
+	creds := credentialsFile{}
+	if cfConfig.CredentialsFile != "" {
+		var err error
+		if creds, err = loadCredentialsFile(cfConfig.CredentialsFile); err != nil {
+			return nil, err
+		}
 	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize cloudflare provider: %v", err)
+	// the environment variables are used unless the credentials file sets default credentials
+	if creds.Default == nil {
+		creds.Default = environmentCredentials()
+	}
+	if creds.Default == nil && len(creds.Accounts) == 0 && len(creds.Zones) == 0 {
+		return nil, fmt.Errorf("failed to initialize cloudflare provider: no credentials configured")
+	}
+
+	// initialize via chosen auth method and return new API objects,
+	// each with its own rate limit as the API limits each user separately
+	newClient := func(c credentials, accountID string) (cloudFlareDNS, error) {
+		opts := []cf.Option{
+			cf.HTTPClient(&http.Client{Transport: newRateLimitedTransport(http.DefaultTransport, cfConfig.RequestsPerSecond)}),
+			cf.UsingRateLimit(math.MaxFloat64),
+			cf.UsingRetryPolicy(cfConfig.APIRetries, 1, 30),
+		}
+		// load balancer pools belong to the account, not to the user
+		if accountID != "" {
+			opts = append(opts, cf.UsingAccount(accountID))
+		}
+		// the requests are paced by the transport, the rate limiter of the client is disabled
+		config, err := c.newAPI(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize cloudflare provider: %v", err)
+		}
+		return zoneService{config}, nil
+	}
+
+	var defaultClient cloudFlareDNS
+	if creds.Default != nil {
+		var err error
+		if defaultClient, err = newClient(*creds.Default, ""); err != nil {
+			return nil, err
+		}
+	}
+	accountClients := map[string]cloudFlareDNS{}
+	for account, c := range creds.Accounts {
+		client, err := newClient(c, account)
+		if err != nil {
+			return nil, err
+		}
+		accountClients[account] = client
+	}
+	zoneClients := map[string]cloudFlareDNS{}
+	for zone, c := range creds.Zones {
+		client, err := newClient(c, "")
+		if err != nil {
+			return nil, err
+		}
+		zoneClients[zone] = client
+	}
+	accountIDFilter := map[string]bool{}
+	for _, account := range cfConfig.AccountIDs {
+		if account != "" {
+			accountIDFilter[account] = true
+		}
+	}
+
+	zoneConcurrency := cfConfig.ZoneConcurrency
+	if zoneConcurrency < 1 {
+		zoneConcurrency = 1
 	}
 	provider := &CloudFlareProvider{
-		Client:           zoneService{config},
-		domainFilter:     domainFilter,
-		zoneIDFilter:     zoneIDFilter,
-		proxiedByDefault: proxiedByDefault,
-		DryRun:           dryRun,
+		Client:           defaultClient,
+		accountClients:   accountClients,
+		zoneClients:      zoneClients,
+		accountIDFilter:  accountIDFilter,
+		domainFilter:     cfConfig.DomainFilter,
+		zoneIDFilter:     cfConfig.ZoneIDFilter,
+		proxiedByDefault: cfConfig.ProxiedByDefault,
+		DryRun:           cfConfig.DryRun,
 		PaginationOptions: cf.PaginationOptions{
-			PerPage: zonesPerPage,
+			PerPage: cfConfig.ZonesPerPage,
 			Page:    1,
 		},
+		zoneConcurrency: zoneConcurrency,
 	}
 	return provider, nil
 }
 
 func (p *CloudFlareProvider) Zones(ctx context.Context) ([]cf.Zone, error) {
 	result := []cf.Zone{}
-	p.PaginationOptions.Page = 1
+	clientsByZoneID := map[string]cloudFlareDNS{}
 
 	// if there is a zoneIDfilter configured
 	// && if the filter isn't just a blank string (used in tests)
@@ -141,38 +311,80 @@ func (p *CloudFlareProvider) Zones(ctx context.Context) ([]cf.Zone, error) {
 		log.Debugln("zoneIDFilter configured, only looking up defined zone IDs")
 		for _, zoneID := range p.zoneIDFilter.ZoneIDs {
 			log.Debugf("looking up zone %s", zoneID)
-			detailResponse, err := p.Client.ZoneDetails(zoneID)
+			detailResponse, err := p.zoneDetails(zoneID)
 			if err != nil {
 				log.Errorf("zone %s lookup failed, %v", zoneID, err)
 				continue
 			}
+			if !p.matchAccount(detailResponse) {
+				log.Debugf("zone %s not in account filter", detailResponse.Name)
+				continue
+			}
 			log.WithFields(log.Fields{
 				"zoneName": detailResponse.Name,
 				"zoneID":   detailResponse.ID,
 			}).Debugln("adding zone for consideration")
 			result = append(result, detailResponse)
+			clientsByZoneID[detailResponse.ID] = p.clientForZone(detailResponse)
 		}
+		p.setZoneClients(clientsByZoneID)
 		return result, nil
 	}
 
 	log.Debugln("no zoneIDFilter configured, looking at all zones")
-	for {
-		zonesResponse, err := p.Client.ListZonesContext(ctx, cf.WithPagination(p.PaginationOptions))
+	for _, lister := range p.zoneListers() {
+		zones, err := p.listZones(ctx, lister)
 		if err != nil {
 			return nil, err
 		}
 
-		for _, zone := range zonesResponse.Result {
+		for _, zone := range zones {
+			if _, ok := clientsByZoneID[zone.ID]; ok {
+				continue
+			}
+			if !p.matchAccount(zone) {
+				log.Debugf("zone %s not in account filter", zone.Name)
+				continue
+			}
 			if !p.domainFilter.Match(zone.Name) {
 				log.Debugf("zone %s not in domain filter", zone.Name)
 				continue
 			}
 			result = append(result, zone)
+			clientsByZoneID[zone.ID] = p.clientForZone(zone)
+		}
+	}
+	p.setZoneClients(clientsByZoneID)
+	return result, nil
+}
+
+// listZones lists all zones visible to the client of the lister
+func (p *CloudFlareProvider) listZones(ctx context.Context, lister zoneLister) ([]cf.Zone, error) {
+	result := []cf.Zone{}
+	pagination := p.PaginationOptions
+	pagination.Page = 1
+
+	for {
+		opts := []cf.ReqOption{cf.WithPagination(pagination)}
+		if lister.accountID != "" {
+			opts = append(opts, cf.WithZoneFilters("", lister.accountID, ""))
+		}
+		zonesResponse, err := lister.client.ListZonesContext(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, zone := range zonesResponse.Result {
+			// zone credentials only manage their own zone, even if they can see others
+			if lister.zone != "" && lister.zone != zone.ID && lister.zone != zone.Name {
+				continue
+			}
+			result = append(result, zone)
 		}
-		if p.PaginationOptions.Page == zonesResponse.ResultInfo.TotalPages {
+		if pagination.Page >= zonesResponse.ResultInfo.TotalPages {
 			break
 		}
-		p.PaginationOptions.Page++
+		pagination.Page++
 	}
 	return result, nil
 }
@@ -183,34 +395,148 @@ func (p *CloudFlareProvider) Records(ctx context.Context) ([]*endpoint.Endpoint,
 		return nil, err
 	}
 
-	endpoints := []*endpoint.Endpoint{}
-	for _, zone := range zones {
-		records, err := p.Client.DNSRecords(zone.ID, cf.DNSRecord{})
+	zoneIDs := make([]string, len(zones))
+	for i, zone := range zones {
+		zoneIDs[i] = zone.ID
+	}
+
+	zoneEndpoints := make([][]*endpoint.Endpoint, len(zones))
+	err = p.forEachZone(ctx, zoneIDs, func(i int, zoneID string) error {
+		records, err := p.listDNSRecords(ctx, zoneID)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		lbs, err := p.listLoadBalancers(zoneID)
+		if err != nil {
+			return err
 		}
 
 		// CloudFlare does not support "sets" of targets, but instead returns
 		// a single entry for each name/type/target, so group by name
 		// and record to allow the planner to calculate the correct plan.
-		endpoints = append(endpoints, groupByNameAndType(records)...)
+		zoneEndpoints[i] = groupByNameAndType(records, lbs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	endpoints := []*endpoint.Endpoint{}
+	for _, e := range zoneEndpoints {
+		endpoints = append(endpoints, e...)
+	}
 	return endpoints, nil
 }
 
+// listDNSRecords fetches all records of a zone page by page
+func (p *CloudFlareProvider) listDNSRecords(ctx context.Context, zoneID string) ([]cf.DNSRecord, error) {
+	client, err := p.clientForZoneID(zoneID)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []cf.DNSRecord{}
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pageRecords, err := client.DNSRecordsPage(zoneID, page, cloudFlareRecordsPerPage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list records of zone %s: %v", zoneID, err)
+		}
+		records = append(records, pageRecords...)
+		if len(pageRecords) < cloudFlareRecordsPerPage {
+			return records, nil
+		}
+	}
+}
+
+// listLoadBalancers fetches the load balancers of a zone. Zones without load balancing
+// may not allow listing them, which is logged and treated as no load balancers.
+func (p *CloudFlareProvider) listLoadBalancers(zoneID string) ([]loadBalancer, error) {
+	client, err := p.clientForZoneID(zoneID)
+	if err != nil {
+		return nil, err
+	}
+	lbs, err := listLoadBalancers(client, zoneID)
+	if err != nil {
+		log.Warnf("Failed to list load balancers of zone %s, skipping them: %v", zoneID, err)
+		return nil, nil
+	}
+	return lbs, nil
+}
+
+// forEachZone calls fn for every zone, with at most zoneConcurrency calls running in parallel.
+// It returns the first error returned by fn, zones not started yet are skipped after an error.
+func (p *CloudFlareProvider) forEachZone(ctx context.Context, zoneIDs []string, fn func(i int, zoneID string) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	sem := make(chan struct{}, p.zoneConcurrency)
+	for i, zoneID := range zoneIDs {
+		if failed() || ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, zoneID string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(i, zoneID); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i, zoneID)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
 func (p *CloudFlareProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
 	cloudflareChanges := []*cloudFlareChange{}
 
+	// changes are submitted in order, a record changing type is deleted before it is
+	// created again as the API rejects a CNAME next to records of other types
+	for i, desired := range changes.ReplaceNew {
+		cloudflareChanges = append(cloudflareChanges, p.newCloudFlareChanges(cloudFlareDelete, changes.ReplaceOld[i])...)
+		cloudflareChanges = append(cloudflareChanges, p.newCloudFlareChanges(cloudFlareCreate, desired)...)
+	}
+
 	for _, endpoint := range changes.Create {
-		for _, target := range endpoint.Targets {
-			cloudflareChanges = append(cloudflareChanges, p.newCloudFlareChange(cloudFlareCreate, endpoint, target))
-		}
+		cloudflareChanges = append(cloudflareChanges, p.newCloudFlareChanges(cloudFlareCreate, endpoint)...)
 	}
 
 	for i, desired := range changes.UpdateNew {
 		current := changes.UpdateOld[i]
 
+		// a hostname moving between records and a load balancer is replaced
+		if isLoadBalancer(desired) || isLoadBalancer(current) {
+			if isLoadBalancer(desired) && isLoadBalancer(current) {
+				cloudflareChanges = append(cloudflareChanges, p.newCloudFlareChanges(cloudFlareUpdate, desired)...)
+				continue
+			}
+			cloudflareChanges = append(cloudflareChanges, p.newCloudFlareChanges(cloudFlareCreate, desired)...)
+			cloudflareChanges = append(cloudflareChanges, p.newCloudFlareChanges(cloudFlareDelete, current)...)
+			continue
+		}
+
 		add, remove, leave := provider.Difference(current.Targets, desired.Targets)
 
 		for _, a := range add {
@@ -227,9 +553,7 @@ func (p *CloudFlareProvider) ApplyChanges(ctx context.Context, changes *plan.Cha
 	}
 
 	for _, endpoint := range changes.Delete {
-		for _, target := range endpoint.Targets {
-			cloudflareChanges = append(cloudflareChanges, p.newCloudFlareChange(cloudFlareDelete, endpoint, target))
-		}
+		cloudflareChanges = append(cloudflareChanges, p.newCloudFlareChanges(cloudFlareDelete, endpoint)...)
 	}
 
 	return p.submitChanges(ctx, cloudflareChanges)
@@ -239,6 +563,14 @@ func (p *CloudFlareProvider) PropertyValuesEqual(name string, previous string, c
 	if name == source.CloudflareProxiedKey {
 		return plan.CompareBoolean(p.proxiedByDefault, name, previous, current)
 	}
+	if name == source.CloudflareLoadBalancerSteeringKey {
+		if previous == "" {
+			previous = defaultSteeringPolicy
+		}
+		if current == "" {
+			current = defaultSteeringPolicy
+		}
+	}
 
 	return p.BaseProvider.PropertyValuesEqual(name, previous, current)
 }
@@ -257,54 +589,112 @@ func (p *CloudFlareProvider) submitChanges(ctx context.Context, changes []*cloud
 	// separate into per-zone change sets to be passed to the API.
 	changesByZone := p.changesByZone(zones, changes)
 
-	for zoneID, changes := range changesByZone {
-		records, err := p.Client.DNSRecords(zoneID, cf.DNSRecord{})
-		if err != nil {
-			return fmt.Errorf("could not fetch records from zone, %v", err)
-		}
-		for _, change := range changes {
-			logFields := log.Fields{
-				"record": change.ResourceRecord.Name,
-				"type":   change.ResourceRecord.Type,
-				"ttl":    change.ResourceRecord.TTL,
-				"action": change.Action,
-				"zone":   zoneID,
-			}
+	zoneIDs := make([]string, 0, len(changesByZone))
+	for zoneID := range changesByZone {
+		zoneIDs = append(zoneIDs, zoneID)
+	}
 
-			log.WithFields(logFields).Info("Changing record.")
+	// a zone failing does not keep the changes of the other zones from being submitted
+	var (
+		mu     sync.Mutex
+		failed []string
+	)
+	err = p.forEachZone(ctx, zoneIDs, func(_ int, zoneID string) error {
+		if err := p.submitZoneChanges(ctx, zoneID, changesByZone[zoneID]); err != nil {
+			mu.Lock()
+			failed = append(failed, fmt.Sprintf("zone %s: %v", zoneID, err))
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to submit all changes: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
 
-			if p.DryRun {
-				continue
+// submitZoneChanges applies the changes of a single zone one by one. A failing change does
+// not stop the others, the failures are returned together once all changes were submitted.
+func (p *CloudFlareProvider) submitZoneChanges(ctx context.Context, zoneID string, changes []*cloudFlareChange) error {
+	client, err := p.clientForZoneID(zoneID)
+	if err != nil {
+		return err
+	}
+	records, err := p.listDNSRecords(ctx, zoneID)
+	if err != nil {
+		return fmt.Errorf("could not fetch records from zone, %v", err)
+	}
+	var failed []string
+	fail := func(change *cloudFlareChange, logFields log.Fields, err error) {
+		log.WithFields(logFields).Error(err)
+		failed = append(failed, fmt.Sprintf("%s %s %s: %v", change.Action, change.ResourceRecord.Name, change.ResourceRecord.Type, err))
+	}
+	for _, change := range changes {
+		logFields := log.Fields{
+			"record": change.ResourceRecord.Name,
+			"type":   change.ResourceRecord.Type,
+			"ttl":    change.ResourceRecord.TTL,
+			"action": change.Action,
+			"zone":   zoneID,
+		}
+
+		log.WithFields(logFields).Info("Changing record.")
+
+		if p.DryRun {
+			continue
+		}
+
+		if change.LoadBalancer != nil {
+			if err := p.submitLoadBalancerChange(client, zoneID, change); err != nil {
+				fail(change, logFields, fmt.Errorf("failed to change load balancer: %v", err))
 			}
+			continue
+		}
 
-			if change.Action == cloudFlareUpdate {
-				recordID := p.getRecordID(records, change.ResourceRecord)
-				if recordID == "" {
-					log.WithFields(logFields).Errorf("failed to find previous record: %v", change.ResourceRecord)
-					continue
-				}
-				err := p.Client.UpdateDNSRecord(zoneID, recordID, change.ResourceRecord)
-				if err != nil {
-					log.WithFields(logFields).Errorf("failed to update record: %v", err)
-				}
-			} else if change.Action == cloudFlareDelete {
-				recordID := p.getRecordID(records, change.ResourceRecord)
-				if recordID == "" {
-					log.WithFields(logFields).Errorf("failed to find previous record: %v", change.ResourceRecord)
-					continue
-				}
-				err := p.Client.DeleteDNSRecord(zoneID, recordID)
-				if err != nil {
-					log.WithFields(logFields).Errorf("failed to delete record: %v", err)
-				}
-			} else if change.Action == cloudFlareCreate {
-				_, err := p.Client.CreateDNSRecord(zoneID, change.ResourceRecord)
-				if err != nil {
-					log.WithFields(logFields).Errorf("failed to create record: %v", err)
-				}
+		if change.Action == cloudFlareUpdate {
+			recordID := p.getRecordID(records, change.ResourceRecord)
+			if recordID == "" {
+				fail(change, logFields, fmt.Errorf("failed to find previous record: %v", change.ResourceRecord))
+				continue
+			}
+			var err error
+			if change.Comment != "" {
+				err = client.UpdateDNSRecordWithComment(zoneID, recordID, change.ResourceRecord, change.Comment)
+			} else {
+				err = client.UpdateDNSRecord(zoneID, recordID, change.ResourceRecord)
+			}
+			if err != nil {
+				fail(change, logFields, fmt.Errorf("failed to update record: %v", err))
+			}
+		} else if change.Action == cloudFlareDelete {
+			recordID := p.getRecordID(records, change.ResourceRecord)
+			if recordID == "" {
+				fail(change, logFields, fmt.Errorf("failed to find previous record: %v", change.ResourceRecord))
+				continue
+			}
+			err := client.DeleteDNSRecord(zoneID, recordID)
+			if err != nil {
+				fail(change, logFields, fmt.Errorf("failed to delete record: %v", err))
+			}
+		} else if change.Action == cloudFlareCreate {
+			var err error
+			if change.Comment != "" {
+				err = client.CreateDNSRecordWithComment(zoneID, change.ResourceRecord, change.Comment)
+			} else {
+				_, err = client.CreateDNSRecord(zoneID, change.ResourceRecord)
+			}
+			if err != nil {
+				fail(change, logFields, fmt.Errorf("failed to create record: %v", err))
 			}
 		}
 	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d changes failed: %s", len(failed), len(changes), strings.Join(failed, "; "))
+	}
 	return nil
 }
 
@@ -312,22 +702,34 @@ func (p *CloudFlareProvider) submitChanges(ctx context.Context, changes []*cloud
 func (p *CloudFlareProvider) AdjustEndpoints(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
 	adjustedEndpoints := []*endpoint.Endpoint{}
 	for _, e := range endpoints {
+		if isLoadBalancer(e) {
+			if err := adjustLoadBalancer(e); err != nil {
+				log.Errorf("Invalid load balancer %s: %v", e.DNSName, err)
+			}
+		}
+		if proxied, ok := proxiedSetting(e); ok && proxied && !canBeProxied(e) {
+			log.Errorf("Rejecting proxying of %s record %s, only non-wildcard A, AAAA and CNAME records can be proxied", e.RecordType, e.DNSName)
+			e.SetProviderSpecificProperty(source.CloudflareProxiedKey, "false")
+		}
 		if shouldBeProxied(e, p.proxiedByDefault) {
+			// proxied records always use automatic TTL
 			e.RecordTTL = 0
+		} else if e.RecordTTL.IsConfigured() {
+			e.RecordTTL = endpoint.TTL(cloudFlareTTL(e.RecordTTL))
 		}
 		adjustedEndpoints = append(adjustedEndpoints, e)
 	}
 	return adjustedEndpoints
 }
 
-// changesByZone separates a multi-zone change into a single change per zone.
+// changesByZone separates a multi-zone change into a single change per zone, zones without
+// changes are left out.
 func (p *CloudFlareProvider) changesByZone(zones []cf.Zone, changeSet []*cloudFlareChange) map[string][]*cloudFlareChange {
 	changes := make(map[string][]*cloudFlareChange)
 	zoneNameIDMapper := provider.ZoneIDName{}
 
 	for _, z := range zones {
 		zoneNameIDMapper.Add(z.ID, z.Name)
-		changes[z.ID] = []*cloudFlareChange{}
 	}
 
 	for _, c := range changeSet {
@@ -344,61 +746,193 @@ func (p *CloudFlareProvider) changesByZone(zones []cf.Zone, changeSet []*cloudFl
 
 func (p *CloudFlareProvider) getRecordID(records []cf.DNSRecord, record cf.DNSRecord) string {
 	for _, zoneRecord := range records {
-		if zoneRecord.Name == record.Name && zoneRecord.Type == record.Type && zoneRecord.Content == record.Content {
+		if zoneRecord.Name == record.Name && zoneRecord.Type == record.Type && recordTarget(zoneRecord) == recordTarget(record) {
 			return zoneRecord.ID
 		}
 	}
 	return ""
 }
 
+// recordTarget returns the endpoint target of a record, for MX, SRV and CAA records
+// it is assembled from the priority and data fields
+func recordTarget(r cf.DNSRecord) string {
+	switch r.Type {
+	case endpoint.RecordTypeMX:
+		return endpoint.MXTarget{Priority: uint16(r.Priority), Host: r.Content}.String()
+	case endpoint.RecordTypeSRV:
+		if data, ok := r.Data.(map[string]interface{}); ok {
+			return fmt.Sprintf("%v %v %v %s", data["priority"], data["weight"], data["port"], strings.TrimSuffix(fmt.Sprint(data["target"]), "."))
+		}
+		return fmt.Sprintf("%d %s", r.Priority, r.Content)
+	case endpoint.RecordTypeCAA:
+		if data, ok := r.Data.(map[string]interface{}); ok {
+			return fmt.Sprintf("%v %v \"%v\"", data["flags"], data["tag"], data["value"])
+		}
+	}
+	return r.Content
+}
+
+// setRecordData fills the content, priority or data fields of a record from the endpoint target
+func setRecordData(record *cf.DNSRecord, dnsName, target string) {
+	switch record.Type {
+	case endpoint.RecordTypeMX:
+		if mx, err := endpoint.ParseMXTarget(target); err == nil {
+			record.Content = mx.Host
+			record.Priority = int(mx.Priority)
+			return
+		}
+	case endpoint.RecordTypeSRV:
+		labels := strings.SplitN(dnsName, ".", 3)
+		if srv, err := endpoint.ParseSRVTarget(target); err == nil && len(labels) == 3 {
+			record.Data = map[string]interface{}{
+				"service":  labels[0],
+				"proto":    labels[1],
+				"name":     labels[2],
+				"priority": srv.Priority,
+				"weight":   srv.Weight,
+				"port":     srv.Port,
+				"target":   srv.Host,
+			}
+			return
+		}
+	case endpoint.RecordTypeCAA:
+		if caa, err := endpoint.ParseCAATarget(target); err == nil {
+			record.Data = map[string]interface{}{
+				"flags": caa.Flags,
+				"tag":   caa.Tag,
+				"value": caa.Value,
+			}
+			return
+		}
+	}
+	record.Content = target
+}
+
+// newCloudFlareChanges returns a change for each target of the endpoint,
+// or a single change of the load balancer serving the endpoint
+func (p *CloudFlareProvider) newCloudFlareChanges(action string, ep *endpoint.Endpoint) []*cloudFlareChange {
+	if !isLoadBalancer(ep) {
+		changes := make([]*cloudFlareChange, len(ep.Targets))
+		for i, target := range ep.Targets {
+			changes[i] = p.newCloudFlareChange(action, ep, target)
+		}
+		return changes
+	}
+
+	lb := &loadBalancer{LoadBalancer: cf.LoadBalancer{Name: ep.DNSName}}
+	if action != cloudFlareDelete {
+		var err error
+		if lb, err = p.newLoadBalancer(ep); err != nil {
+			log.Errorf("Skipping load balancer change: %v", err)
+			return nil
+		}
+	}
+	return []*cloudFlareChange{{
+		Action: action,
+		ResourceRecord: cf.DNSRecord{
+			Name: ep.DNSName,
+			TTL:  lb.TTL,
+			Type: ep.RecordType,
+		},
+		LoadBalancer: lb,
+	}}
+}
+
 func (p *CloudFlareProvider) newCloudFlareChange(action string, endpoint *endpoint.Endpoint, target string) *cloudFlareChange {
 	ttl := defaultCloudFlareRecordTTL
 	proxied := shouldBeProxied(endpoint, p.proxiedByDefault)
 
-	if endpoint.RecordTTL.IsConfigured() {
-		ttl = int(endpoint.RecordTTL)
+	if endpoint.RecordTTL.IsConfigured() && !proxied {
+		ttl = cloudFlareTTL(endpoint.RecordTTL)
 	}
 
 	if len(endpoint.Targets) > 1 {
 		log.Errorf("Updates should have just one target")
 	}
 
+	record := cf.DNSRecord{
+		Name:    endpoint.DNSName,
+		TTL:     ttl,
+		Proxied: proxied,
+		Type:    endpoint.RecordType,
+	}
+	setRecordData(&record, endpoint.DNSName, target)
+
 	return &cloudFlareChange{
-		Action: action,
-		ResourceRecord: cf.DNSRecord{
-			Name:    endpoint.DNSName,
-			TTL:     ttl,
-			Proxied: proxied,
-			Type:    endpoint.RecordType,
-			Content: target,
-		},
+		Action:         action,
+		ResourceRecord: record,
+		Comment:        ownerComment(endpoint),
+	}
+}
+
+// cloudFlareTTL clamps a configured TTL to the range Cloudflare accepts for non-automatic TTLs
+func cloudFlareTTL(ttl endpoint.TTL) int {
+	if ttl < minCloudFlareRecordTTL {
+		return minCloudFlareRecordTTL
+	}
+	if ttl > maxCloudFlareRecordTTL {
+		return maxCloudFlareRecordTTL
+	}
+	return int(ttl)
+}
+
+// ownerComment returns the record comment naming the dops instance which owns the endpoint, if any
+func ownerComment(ep *endpoint.Endpoint) string {
+	owner := ep.Labels[endpoint.OwnerLabelKey]
+	if owner == "" {
+		return ""
+	}
+	return fmt.Sprintf("managed by dops, owner: %s", owner)
+}
+
+// proxiedSetting returns the value of the proxied key of the endpoint, provider specific
+// properties take precedence over labels
+func proxiedSetting(ep *endpoint.Endpoint) (bool, bool) {
+	value := ""
+	if prop, ok := ep.GetProviderSpecificProperty(source.CloudflareProxiedKey); ok {
+		value = prop.Value
+	} else if label, ok := ep.Labels[source.CloudflareProxiedKey]; ok {
+		value = label
+	} else {
+		return false, false
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Errorf("Failed to parse annotation [%s]: %v", source.CloudflareProxiedKey, err)
+		return false, false
 	}
+	return b, true
+}
+
+// canBeProxied returns true if traffic to the endpoint can go through Cloudflare
+func canBeProxied(ep *endpoint.Endpoint) bool {
+	return cloudFlareProxiableTypes[ep.RecordType] && !strings.Contains(ep.DNSName, "*")
 }
 
 func shouldBeProxied(endpoint *endpoint.Endpoint, proxiedByDefault bool) bool {
 	proxied := proxiedByDefault
 
-	for _, v := range endpoint.ProviderSpecific {
-		if v.Name == source.CloudflareProxiedKey {
-			b, err := strconv.ParseBool(v.Value)
-			if err != nil {
-				log.Errorf("Failed to parse annotation [%s]: %v", source.CloudflareProxiedKey, err)
-			} else {
-				proxied = b
-			}
-			break
-		}
+	if b, ok := proxiedSetting(endpoint); ok {
+		proxied = b
 	}
 
-	if cloudFlareTypeNotSupported[endpoint.RecordType] || strings.Contains(endpoint.DNSName, "*") {
+	if !canBeProxied(endpoint) {
 		proxied = false
 	}
 	return proxied
 }
 
-func groupByNameAndType(records []cf.DNSRecord) []*endpoint.Endpoint {
+func groupByNameAndType(records []cf.DNSRecord, lbs []loadBalancer) []*endpoint.Endpoint {
 	endpoints := []*endpoint.Endpoint{}
 
+	// load balancers take precedence over records with the same name
+	lbNames := map[string]bool{}
+	for _, lb := range lbs {
+		lbNames[lb.Name] = true
+		endpoints = append(endpoints, loadBalancerEndpoint(lb))
+	}
+
 	// group supported records by name and type
 	groups := map[string][]cf.DNSRecord{}
 
@@ -406,6 +940,9 @@ func groupByNameAndType(records []cf.DNSRecord) []*endpoint.Endpoint {
 		if !provider.SupportedRecordType(r.Type) {
 			continue
 		}
+		if lbNames[r.Name] && (r.Type == endpoint.RecordTypeA || r.Type == endpoint.RecordTypeAAAA || r.Type == endpoint.RecordTypeCNAME) {
+			continue
+		}
 
 		groupBy := r.Name + r.Type
 		if _, ok := groups[groupBy]; !ok {
@@ -419,7 +956,7 @@ func groupByNameAndType(records []cf.DNSRecord) []*endpoint.Endpoint {
 	for _, records := range groups {
 		targets := make([]string, len(records))
 		for i, record := range records {
-			targets[i] = record.Content
+			targets[i] = recordTarget(record)
 		}
 		endpoints = append(endpoints,
 			endpoint.NewEndpointWithTTL(
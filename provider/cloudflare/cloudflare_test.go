@@ -0,0 +1,284 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cf "github.com/cloudflare/cloudflare-go"
+
+	"github.com/toppr-systems/dops/endpoint"
+	"github.com/toppr-systems/dops/plan"
+	"github.com/toppr-systems/dops/registry"
+	"github.com/toppr-systems/dops/source"
+)
+
+// fakeCloudFlareDNS keeps records and load balancers in memory and counts the calls to the API
+type fakeCloudFlareDNS struct {
+	mu            sync.Mutex
+	zones         []cf.Zone
+	records       map[string][]cf.DNSRecord
+	loadBalancers map[string][]cf.LoadBalancer
+	pools         []cf.LoadBalancerPool
+	// pageCalls counts the calls to DNSRecordsPage by zone
+	pageCalls map[string]int
+	// failCreates fails the creation of the records with these names
+	failCreates map[string]bool
+	nextID      int
+}
+
+func newFakeCloudFlareDNS(zones ...string) *fakeCloudFlareDNS {
+	f := &fakeCloudFlareDNS{
+		records:       map[string][]cf.DNSRecord{},
+		loadBalancers: map[string][]cf.LoadBalancer{},
+		pageCalls:     map[string]int{},
+		failCreates:   map[string]bool{},
+	}
+	for _, name := range zones {
+		f.zones = append(f.zones, cf.Zone{ID: "id-" + name, Name: name})
+	}
+	return f
+}
+
+func (f *fakeCloudFlareDNS) id() string {
+	f.nextID++
+	return fmt.Sprintf("%d", f.nextID)
+}
+
+func (f *fakeCloudFlareDNS) UserDetails() (cf.User, error) { return cf.User{}, nil }
+
+func (f *fakeCloudFlareDNS) ZoneIDByName(zoneName string) (string, error) {
+	return "id-" + zoneName, nil
+}
+
+func (f *fakeCloudFlareDNS) ListZones(zoneID ...string) ([]cf.Zone, error) { return f.zones, nil }
+
+func (f *fakeCloudFlareDNS) ListZonesContext(ctx context.Context, opts ...cf.ReqOption) (cf.ZonesResponse, error) {
+	return cf.ZonesResponse{Result: f.zones, ResultInfo: cf.ResultInfo{Page: 1, TotalPages: 1}}, nil
+}
+
+func (f *fakeCloudFlareDNS) ZoneDetails(zoneID string) (cf.Zone, error) {
+	for _, z := range f.zones {
+		if z.ID == zoneID {
+			return z, nil
+		}
+	}
+	return cf.Zone{}, fmt.Errorf("zone %s not found", zoneID)
+}
+
+func (f *fakeCloudFlareDNS) DNSRecords(zoneID string, rr cf.DNSRecord) ([]cf.DNSRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]cf.DNSRecord{}, f.records[zoneID]...), nil
+}
+
+func (f *fakeCloudFlareDNS) DNSRecordsPage(zoneID string, page, perPage int) ([]cf.DNSRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pageCalls[zoneID]++
+	records := f.records[zoneID]
+	start := (page - 1) * perPage
+	if start >= len(records) {
+		return nil, nil
+	}
+	end := start + perPage
+	if end > len(records) {
+		end = len(records)
+	}
+	return append([]cf.DNSRecord{}, records[start:end]...), nil
+}
+
+func (f *fakeCloudFlareDNS) CreateDNSRecord(zoneID string, rr cf.DNSRecord) (*cf.DNSRecordResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failCreates[rr.Name] {
+		return nil, fmt.Errorf("failed to create %s", rr.Name)
+	}
+	rr.ID = f.id()
+	f.records[zoneID] = append(f.records[zoneID], rr)
+	return &cf.DNSRecordResponse{Result: rr}, nil
+}
+
+func (f *fakeCloudFlareDNS) DeleteDNSRecord(zoneID, recordID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records := []cf.DNSRecord{}
+	for _, r := range f.records[zoneID] {
+		if r.ID != recordID {
+			records = append(records, r)
+		}
+	}
+	f.records[zoneID] = records
+	return nil
+}
+
+func (f *fakeCloudFlareDNS) UpdateDNSRecord(zoneID, recordID string, rr cf.DNSRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, r := range f.records[zoneID] {
+		if r.ID == recordID {
+			rr.ID = recordID
+			f.records[zoneID][i] = rr
+		}
+	}
+	return nil
+}
+
+func (f *fakeCloudFlareDNS) CreateDNSRecordWithComment(zoneID string, rr cf.DNSRecord, comment string) error {
+	_, err := f.CreateDNSRecord(zoneID, rr)
+	return err
+}
+
+func (f *fakeCloudFlareDNS) UpdateDNSRecordWithComment(zoneID, recordID string, rr cf.DNSRecord, comment string) error {
+	return f.UpdateDNSRecord(zoneID, recordID, rr)
+}
+
+func (f *fakeCloudFlareDNS) ListLoadBalancers(zoneID string) ([]cf.LoadBalancer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]cf.LoadBalancer{}, f.loadBalancers[zoneID]...), nil
+}
+
+func (f *fakeCloudFlareDNS) CreateLoadBalancer(zoneID string, lb cf.LoadBalancer) (cf.LoadBalancer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lb.ID = f.id()
+	f.loadBalancers[zoneID] = append(f.loadBalancers[zoneID], lb)
+	return lb, nil
+}
+
+func (f *fakeCloudFlareDNS) ModifyLoadBalancer(zoneID string, lb cf.LoadBalancer) (cf.LoadBalancer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, c := range f.loadBalancers[zoneID] {
+		if c.ID == lb.ID {
+			f.loadBalancers[zoneID][i] = lb
+		}
+	}
+	return lb, nil
+}
+
+func (f *fakeCloudFlareDNS) DeleteLoadBalancer(zoneID, lbID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lbs := []cf.LoadBalancer{}
+	for _, lb := range f.loadBalancers[zoneID] {
+		if lb.ID != lbID {
+			lbs = append(lbs, lb)
+		}
+	}
+	f.loadBalancers[zoneID] = lbs
+	return nil
+}
+
+func (f *fakeCloudFlareDNS) ListLoadBalancerPools() ([]cf.LoadBalancerPool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]cf.LoadBalancerPool{}, f.pools...), nil
+}
+
+func (f *fakeCloudFlareDNS) CreateLoadBalancerPool(pool cf.LoadBalancerPool) (cf.LoadBalancerPool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pool.ID = f.id()
+	f.pools = append(f.pools, pool)
+	return pool, nil
+}
+
+func (f *fakeCloudFlareDNS) ModifyLoadBalancerPool(pool cf.LoadBalancerPool) (cf.LoadBalancerPool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, p := range f.pools {
+		if p.ID == pool.ID {
+			f.pools[i] = pool
+		}
+	}
+	return pool, nil
+}
+
+func newTestCloudFlareProvider(client *fakeCloudFlareDNS) *CloudFlareProvider {
+	return &CloudFlareProvider{
+		Client:            client,
+		PaginationOptions: cf.PaginationOptions{PerPage: 50, Page: 1},
+		zoneConcurrency:   1,
+	}
+}
+
+func TestCloudFlareLoadBalancerOwnershipRecord(t *testing.T) {
+	client := newFakeCloudFlareDNS("example.com")
+	p := newTestCloudFlareProvider(client)
+	r, err := registry.NewTXTRegistry(p, "txt-", "", "owner", time.Duration(0), "", registry.TXTFormatLegacy, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep := endpoint.NewEndpoint("lb.example.com", endpoint.RecordTypeA, "192.0.2.1").
+		WithProviderSpecific(source.CloudflareLoadBalancerKey, "primary=192.0.2.1")
+	if err := r.ApplyChanges(context.Background(), &plan.Changes{Create: []*endpoint.Endpoint{ep}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if lbs := client.loadBalancers["id-example.com"]; len(lbs) != 1 || lbs[0].Name != "lb.example.com" {
+		t.Errorf("expected only the load balancer lb.example.com, got %v", lbs)
+	}
+	records := client.records["id-example.com"]
+	if len(records) != 1 || records[0].Name != "txt-lb.example.com" || records[0].Type != endpoint.RecordTypeTXT {
+		t.Fatalf("expected the ownership record txt-lb.example.com, got %v", records)
+	}
+
+	endpoints, err := r.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned := false
+	for _, ep := range endpoints {
+		if ep.DNSName == "lb.example.com" {
+			owned = ep.Labels[endpoint.OwnerLabelKey] == "owner"
+		}
+	}
+	if !owned {
+		t.Errorf("expected lb.example.com to be owned, got %v", endpoints)
+	}
+}
+
+func TestCloudFlareSubmitChangesSkipsZonesWithoutChanges(t *testing.T) {
+	client := newFakeCloudFlareDNS("example.com", "example.org")
+	p := newTestCloudFlareProvider(client)
+
+	changes := &plan.Changes{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.1")}}
+	if err := p.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatal(err)
+	}
+	if calls := client.pageCalls["id-example.org"]; calls != 0 {
+		t.Errorf("expected the records of example.org not to be listed, got %d calls", calls)
+	}
+	if len(client.records["id-example.com"]) != 1 {
+		t.Errorf("expected foo.example.com to be created, got %v", client.records["id-example.com"])
+	}
+}
+
+func TestCloudFlareSubmitChangesReturnsFailures(t *testing.T) {
+	client := newFakeCloudFlareDNS("example.com", "example.org")
+	client.failCreates["bar.example.com"] = true
+	p := newTestCloudFlareProvider(client)
+
+	changes := &plan.Changes{Create: []*endpoint.Endpoint{
+		endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.1"),
+		endpoint.NewEndpoint("bar.example.com", endpoint.RecordTypeA, "192.0.2.2"),
+		endpoint.NewEndpoint("foo.example.org", endpoint.RecordTypeA, "192.0.2.3"),
+	}}
+	err := p.ApplyChanges(context.Background(), changes)
+	if err == nil || !strings.Contains(err.Error(), "bar.example.com") {
+		t.Fatalf("expected the creation of bar.example.com to fail, got %v", err)
+	}
+	// the other changes are submitted nonetheless
+	if records := client.records["id-example.com"]; len(records) != 1 || records[0].Name != "foo.example.com" {
+		t.Errorf("expected foo.example.com to be created, got %v", records)
+	}
+	if records := client.records["id-example.org"]; len(records) != 1 {
+		t.Errorf("expected foo.example.org to be created, got %v", records)
+	}
+}
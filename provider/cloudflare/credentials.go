@@ -0,0 +1,90 @@
+package cloudflare
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	cf "github.com/cloudflare/cloudflare-go"
+	"gopkg.in/yaml.v2"
+)
+
+// credentials authenticate against the Cloudflare API, either with a scoped API token
+// or with a global API key and the email address of the account
+type credentials struct {
+	APIToken string `yaml:"apiToken"`
+	APIKey   string `yaml:"apiKey"`
+	APIEmail string `yaml:"apiEmail"`
+}
+
+// credentialsFile maps Cloudflare accounts and zones to the credentials used to manage them,
+// zone credentials take precedence over account credentials, which take precedence over the default
+//
+//	default:
+//	  apiToken: <token>
+//	accounts:
+//	  <account ID>:
+//	    apiToken: <token>
+//	zones:
+//	  <zone ID or name>:
+//	    apiKey: <key>
+//	    apiEmail: <email>
+type credentialsFile struct {
+	Default  *credentials           `yaml:"default"`
+	Accounts map[string]credentials `yaml:"accounts"`
+	Zones    map[string]credentials `yaml:"zones"`
+}
+
+func loadCredentialsFile(path string) (credentialsFile, error) {
+	c := credentialsFile{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, fmt.Errorf("failed to read cloudflare credentials file: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return c, fmt.Errorf("failed to parse cloudflare credentials file %s: %v", path, err)
+	}
+	for account, creds := range c.Accounts {
+		if err := creds.validate(); err != nil {
+			return c, fmt.Errorf("invalid credentials for account %s: %v", account, err)
+		}
+	}
+	for zone, creds := range c.Zones {
+		if err := creds.validate(); err != nil {
+			return c, fmt.Errorf("invalid credentials for zone %s: %v", zone, err)
+		}
+	}
+	if c.Default != nil {
+		if err := c.Default.validate(); err != nil {
+			return c, fmt.Errorf("invalid default credentials: %v", err)
+		}
+	}
+	return c, nil
+}
+
+// environmentCredentials returns the credentials set in the CF_API_TOKEN or CF_API_KEY
+// and CF_API_EMAIL environment variables, if any
+func environmentCredentials() *credentials {
+	if os.Getenv("CF_API_TOKEN") != "" {
+		return &credentials{APIToken: os.Getenv("CF_API_TOKEN")}
+	}
+	if os.Getenv("CF_API_KEY") != "" {
+		return &credentials{APIKey: os.Getenv("CF_API_KEY"), APIEmail: os.Getenv("CF_API_EMAIL")}
+	}
+	return nil
+}
+
+func (c credentials) validate() error {
+	if c.APIToken == "" && (c.APIKey == "" || c.APIEmail == "") {
+		return fmt.Errorf("either apiToken or apiKey and apiEmail must be set")
+	}
+	return nil
+}
+
+// newAPI creates a Cloudflare API client authenticating with the credentials
+func (c credentials) newAPI(opts ...cf.Option) (*cf.API, error) {
+	if c.APIToken != "" {
+		return cf.NewWithAPIToken(c.APIToken, opts...)
+	}
+	return cf.New(c.APIKey, c.APIEmail, opts...)
+}
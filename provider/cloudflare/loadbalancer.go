@@ -0,0 +1,342 @@
+package cloudflare
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	cf "github.com/cloudflare/cloudflare-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/toppr-systems/dops/endpoint"
+	"github.com/toppr-systems/dops/source"
+)
+
+const (
+	// defaultOriginWeight is the weight of origins which do not set one
+	defaultOriginWeight = 1
+	// defaultSteeringPolicy is the steering policy Cloudflare uses when none is set
+	defaultSteeringPolicy = "off"
+)
+
+// loadBalancerPool is a pool of a load balancer, named after the Cloudflare pool
+type loadBalancerPool struct {
+	Name    string
+	Origins []cf.LoadBalancerOrigin
+}
+
+// loadBalancer is a Cloudflare load balancer together with the pools it references
+type loadBalancer struct {
+	cf.LoadBalancer
+	Pools []loadBalancerPool
+}
+
+// parseLoadBalancerPools parses the pools of the load balancer annotation,
+// e.g. "primary=10.0.0.1,10.0.0.2@0.5;backup=10.1.0.1". The order of the pools is their failover order.
+func parseLoadBalancerPools(value string) ([]loadBalancerPool, error) {
+	pools := []loadBalancerPool{}
+	seen := map[string]bool{}
+	for _, poolSpec := range strings.Split(value, ";") {
+		poolSpec = strings.TrimSpace(poolSpec)
+		if poolSpec == "" {
+			continue
+		}
+		parts := strings.SplitN(poolSpec, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid load balancer pool %q, expected \"<pool>=<origin>[@<weight>],...\"", poolSpec)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate load balancer pool %q", name)
+		}
+		seen[name] = true
+
+		pool := loadBalancerPool{Name: name}
+		for _, originSpec := range strings.Split(parts[1], ",") {
+			originSpec = strings.TrimSpace(originSpec)
+			if originSpec == "" {
+				continue
+			}
+			origin := cf.LoadBalancerOrigin{Enabled: true, Weight: defaultOriginWeight}
+			address := originSpec
+			if i := strings.LastIndex(originSpec, "@"); i >= 0 {
+				weight, err := strconv.ParseFloat(originSpec[i+1:], 64)
+				if err != nil || weight < 0 || weight > 1 {
+					return nil, fmt.Errorf("invalid weight of origin %q, expected a number between 0 and 1", originSpec)
+				}
+				address, origin.Weight = originSpec[:i], weight
+			}
+			origin.Address = strings.TrimSuffix(address, ".")
+			origin.Name = origin.Address
+			pool.Origins = append(pool.Origins, origin)
+		}
+		if len(pool.Origins) == 0 {
+			return nil, fmt.Errorf("load balancer pool %q has no origins", name)
+		}
+		sortOrigins(pool.Origins)
+		pools = append(pools, pool)
+	}
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("load balancer has no pools")
+	}
+	return pools, nil
+}
+
+// formatLoadBalancerPools returns the canonical form of the load balancer annotation
+func formatLoadBalancerPools(pools []loadBalancerPool) string {
+	poolSpecs := make([]string, len(pools))
+	for i, pool := range pools {
+		originSpecs := make([]string, len(pool.Origins))
+		for j, origin := range pool.Origins {
+			originSpecs[j] = origin.Address
+			if origin.Weight != defaultOriginWeight {
+				originSpecs[j] += "@" + strconv.FormatFloat(origin.Weight, 'f', -1, 64)
+			}
+		}
+		poolSpecs[i] = pool.Name + "=" + strings.Join(originSpecs, ",")
+	}
+	return strings.Join(poolSpecs, ";")
+}
+
+func sortOrigins(origins []cf.LoadBalancerOrigin) {
+	sort.Slice(origins, func(i, j int) bool {
+		return origins[i].Address < origins[j].Address
+	})
+}
+
+// isLoadBalancer returns true if the endpoint is served by a Cloudflare load balancer,
+// TXT records never are
+func isLoadBalancer(ep *endpoint.Endpoint) bool {
+	if ep.RecordType == endpoint.RecordTypeTXT {
+		return false
+	}
+	prop, ok := ep.GetProviderSpecificProperty(source.CloudflareLoadBalancerKey)
+	return ok && prop.Value != ""
+}
+
+// adjustLoadBalancer rewrites the load balancer annotation of the endpoint in its canonical form,
+// and sets the targets and record type of the endpoint from the origins, as they are read back
+func adjustLoadBalancer(ep *endpoint.Endpoint) error {
+	prop, _ := ep.GetProviderSpecificProperty(source.CloudflareLoadBalancerKey)
+	pools, err := parseLoadBalancerPools(prop.Value)
+	if err != nil {
+		return err
+	}
+	ep.SetProviderSpecificProperty(source.CloudflareLoadBalancerKey, formatLoadBalancerPools(pools))
+	ep.Targets, ep.RecordType = originTargets(pools)
+	return nil
+}
+
+// originTargets returns the addresses of all origins of the pools and the matching record type,
+// A or AAAA if all of them are IPv4 or IPv6 addresses, CNAME otherwise
+func originTargets(pools []loadBalancerPool) (endpoint.Targets, string) {
+	seen := map[string]bool{}
+	targets := endpoint.Targets{}
+	v4, v6 := 0, 0
+	for _, pool := range pools {
+		for _, origin := range pool.Origins {
+			if seen[origin.Address] {
+				continue
+			}
+			seen[origin.Address] = true
+			targets = append(targets, origin.Address)
+			if ip := net.ParseIP(origin.Address); ip != nil {
+				if ip.To4() != nil {
+					v4++
+				} else {
+					v6++
+				}
+			}
+		}
+	}
+	sort.Strings(targets)
+
+	switch len(targets) {
+	case v4:
+		return targets, endpoint.RecordTypeA
+	case v6:
+		return targets, endpoint.RecordTypeAAAA
+	default:
+		return targets, endpoint.RecordTypeCNAME
+	}
+}
+
+// loadBalancerEndpoint returns the endpoint of a load balancer read back from Cloudflare
+func loadBalancerEndpoint(lb loadBalancer) *endpoint.Endpoint {
+	targets, recordType := originTargets(lb.Pools)
+	ttl := endpoint.TTL(lb.TTL)
+	if lb.Proxied {
+		ttl = 0
+	}
+	ep := endpoint.NewEndpointWithTTL(lb.Name, recordType, ttl, targets...).
+		WithProviderSpecific(source.CloudflareProxiedKey, strconv.FormatBool(lb.Proxied)).
+		WithProviderSpecific(source.CloudflareLoadBalancerKey, formatLoadBalancerPools(lb.Pools))
+	if lb.SteeringPolicy != "" {
+		ep.WithProviderSpecific(source.CloudflareLoadBalancerSteeringKey, lb.SteeringPolicy)
+	}
+	return ep
+}
+
+// listLoadBalancers returns the load balancers of a zone, with the pools they use by default
+func listLoadBalancers(client cloudFlareDNS, zoneID string) ([]loadBalancer, error) {
+	lbs, err := client.ListLoadBalancers(zoneID)
+	if err != nil {
+		return nil, err
+	}
+	if len(lbs) == 0 {
+		return nil, nil
+	}
+	pools, err := client.ListLoadBalancerPools()
+	if err != nil {
+		return nil, err
+	}
+	poolsByID := map[string]cf.LoadBalancerPool{}
+	for _, pool := range pools {
+		poolsByID[pool.ID] = pool
+	}
+
+	result := make([]loadBalancer, len(lbs))
+	for i, lb := range lbs {
+		result[i] = loadBalancer{LoadBalancer: lb}
+		for _, poolID := range lb.DefaultPools {
+			pool, ok := poolsByID[poolID]
+			if !ok {
+				log.Warnf("Load balancer %s references unknown pool %s", lb.Name, poolID)
+				continue
+			}
+			// disabled origins are left out, so that they are enabled again
+			origins := []cf.LoadBalancerOrigin{}
+			for _, origin := range pool.Origins {
+				if origin.Enabled {
+					origins = append(origins, origin)
+				}
+			}
+			sortOrigins(origins)
+			result[i].Pools = append(result[i].Pools, loadBalancerPool{Name: pool.Name, Origins: origins})
+		}
+	}
+	return result, nil
+}
+
+// newLoadBalancer returns the load balancer serving the endpoint
+func (p *CloudFlareProvider) newLoadBalancer(ep *endpoint.Endpoint) (*loadBalancer, error) {
+	prop, _ := ep.GetProviderSpecificProperty(source.CloudflareLoadBalancerKey)
+	pools, err := parseLoadBalancerPools(prop.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid load balancer %s: %v", ep.DNSName, err)
+	}
+
+	lb := &loadBalancer{
+		LoadBalancer: cf.LoadBalancer{
+			Name:        ep.DNSName,
+			TTL:         defaultCloudFlareRecordTTL,
+			Proxied:     shouldBeProxied(ep, p.proxiedByDefault),
+			Description: ownerComment(ep),
+		},
+		Pools: pools,
+	}
+	if ep.RecordTTL.IsConfigured() && !lb.Proxied {
+		lb.TTL = cloudFlareTTL(ep.RecordTTL)
+	}
+	if steering, ok := ep.GetProviderSpecificProperty(source.CloudflareLoadBalancerSteeringKey); ok {
+		lb.SteeringPolicy = steering.Value
+	}
+	return lb, nil
+}
+
+// ensurePools creates or updates the pools of the load balancer and returns their IDs.
+// Pools are found by name, as they are shared by all load balancers of the account.
+func ensurePools(client cloudFlareDNS, pools []loadBalancerPool) ([]string, error) {
+	existing, err := client.ListLoadBalancerPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list load balancer pools: %v", err)
+	}
+	poolsByName := map[string]cf.LoadBalancerPool{}
+	for _, pool := range existing {
+		poolsByName[pool.Name] = pool
+	}
+
+	ids := make([]string, len(pools))
+	for i, desired := range pools {
+		pool, ok := poolsByName[desired.Name]
+		if !ok {
+			created, err := client.CreateLoadBalancerPool(cf.LoadBalancerPool{
+				Name:        desired.Name,
+				Description: "managed by dops",
+				Enabled:     true,
+				Origins:     desired.Origins,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create load balancer pool %s: %v", desired.Name, err)
+			}
+			ids[i] = created.ID
+			continue
+		}
+
+		current := loadBalancerPool{Name: pool.Name, Origins: append([]cf.LoadBalancerOrigin{}, pool.Origins...)}
+		sortOrigins(current.Origins)
+		changed := formatLoadBalancerPools([]loadBalancerPool{current}) != formatLoadBalancerPools([]loadBalancerPool{desired})
+		if changed || !pool.Enabled || !allEnabled(pool.Origins) {
+			pool.Enabled = true
+			pool.Origins = desired.Origins
+			if _, err := client.ModifyLoadBalancerPool(pool); err != nil {
+				return nil, fmt.Errorf("failed to update load balancer pool %s: %v", desired.Name, err)
+			}
+		}
+		ids[i] = pool.ID
+	}
+	return ids, nil
+}
+
+func allEnabled(origins []cf.LoadBalancerOrigin) bool {
+	for _, origin := range origins {
+		if !origin.Enabled {
+			return false
+		}
+	}
+	return true
+}
+
+// submitLoadBalancerChange creates, updates or deletes the load balancer of a change.
+// Pools are not deleted together with the load balancer, other load balancers may use them.
+func (p *CloudFlareProvider) submitLoadBalancerChange(client cloudFlareDNS, zoneID string, change *cloudFlareChange) error {
+	lb := change.LoadBalancer
+
+	var lbID string
+	if change.Action != cloudFlareCreate {
+		current, err := client.ListLoadBalancers(zoneID)
+		if err != nil {
+			return fmt.Errorf("failed to list load balancers: %v", err)
+		}
+		for _, c := range current {
+			if strings.EqualFold(strings.TrimSuffix(c.Name, "."), strings.TrimSuffix(lb.Name, ".")) {
+				lbID = c.ID
+				break
+			}
+		}
+		if lbID == "" {
+			return fmt.Errorf("failed to find previous load balancer %s", lb.Name)
+		}
+	}
+
+	if change.Action == cloudFlareDelete {
+		return client.DeleteLoadBalancer(zoneID, lbID)
+	}
+
+	poolIDs, err := ensurePools(client, lb.Pools)
+	if err != nil {
+		return err
+	}
+	lb.DefaultPools = poolIDs
+	lb.FallbackPool = poolIDs[len(poolIDs)-1]
+
+	if change.Action == cloudFlareCreate {
+		_, err = client.CreateLoadBalancer(zoneID, lb.LoadBalancer)
+		return err
+	}
+	lb.ID = lbID
+	_, err = client.ModifyLoadBalancer(zoneID, lb.LoadBalancer)
+	return err
+}
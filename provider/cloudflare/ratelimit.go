@@ -0,0 +1,128 @@
+package cloudflare
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultCloudFlareRequestsPerSecond equates to the default API limit of 1200 requests per 5 minutes
+	defaultCloudFlareRequestsPerSecond = 4
+	// defaultThrottleBackoff is used when a throttled response does not carry a Retry-After header
+	defaultThrottleBackoff = 5 * time.Second
+	maxThrottleBackoff     = 5 * time.Minute
+)
+
+var (
+	apiCallsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dops",
+			Subsystem: "cloudflare",
+			Name:      "api_calls_total",
+			Help:      "Number of calls made to the Cloudflare API.",
+		},
+		[]string{"method"},
+	)
+	apiThrottlesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "dops",
+			Subsystem: "cloudflare",
+			Name:      "api_throttles_total",
+			Help:      "Number of calls to the Cloudflare API rejected with 429 Too Many Requests.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(apiCallsTotal)
+	prometheus.MustRegister(apiThrottlesTotal)
+}
+
+// rateLimitedTransport paces the requests sent to the Cloudflare API with a token bucket
+// and holds back all requests for a while once the API starts throttling.
+// Throttled requests are retried by the Cloudflare client.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+
+	mu           sync.Mutex
+	blockedUntil time.Time
+	backoff      time.Duration
+}
+
+func newRateLimitedTransport(next http.RoundTripper, requestsPerSecond float64) *rateLimitedTransport {
+	if requestsPerSecond <= 0 {
+		requestsPerSecond = defaultCloudFlareRequestsPerSecond
+	}
+	return &rateLimitedTransport{
+		next:    next,
+		limiter: rate.NewLimiter(rate.Limit(requestsPerSecond), 1),
+	}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if wait := t.blockedFor(); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	apiCallsTotal.WithLabelValues(req.Method).Inc()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		apiThrottlesTotal.Inc()
+		backoff := t.throttled(resp.Header.Get("Retry-After"))
+		log.Warnf("Cloudflare API throttled %s %s, backing off for %s", req.Method, req.URL.Path, backoff)
+	} else {
+		t.reset()
+	}
+	return resp, nil
+}
+
+// blockedFor returns how long requests are held back after the API throttled a request
+func (t *rateLimitedTransport) blockedFor() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Until(t.blockedUntil)
+}
+
+// throttled holds back all requests for the time requested by the API,
+// or doubles the previous backoff if the API does not say how long to wait
+func (t *rateLimitedTransport) throttled(retryAfter string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		t.backoff = time.Duration(seconds) * time.Second
+	} else if t.backoff == 0 {
+		t.backoff = defaultThrottleBackoff
+	} else {
+		t.backoff *= 2
+	}
+	if t.backoff > maxThrottleBackoff {
+		t.backoff = maxThrottleBackoff
+	}
+	t.blockedUntil = time.Now().Add(t.backoff)
+	return t.backoff
+}
+
+func (t *rateLimitedTransport) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.backoff = 0
+}
@@ -0,0 +1,154 @@
+package inmemory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+var (
+	// ErrInjectedFailure error returned by ApplyChanges when a failure was injected
+	ErrInjectedFailure = errors.New("injected failure")
+	// ErrThrottled error returned when a throttling error was injected
+	ErrThrottled = errors.New("rate exceeded")
+)
+
+// faults configures the failures and delays injected into the InMemoryProvider,
+// which allows testing how the controller behaves when a provider misbehaves
+type faults struct {
+	// failEveryNthApply fails every nth call to ApplyChanges
+	failEveryNthApply int
+	// failZones fails ApplyChanges once changes for one of these zones are applied,
+	// changes of the other zones may have been applied already
+	failZones map[string]bool
+	// throttleEveryNthCall throttles every nth call to Records or ApplyChanges
+	throttleEveryNthCall int
+	// latency is added to every call to Records or ApplyChanges
+	latency time.Duration
+	// recordsLag is the number of ApplyChanges calls Records lags behind
+	recordsLag int
+
+	// mu guards the counters and history below
+	mu         sync.Mutex
+	applyCount int
+	callCount  int
+	// history holds the records before each of the last recordsLag calls to ApplyChanges
+	history []map[string][]*endpoint.Endpoint
+}
+
+func newFaults() *faults {
+	return &faults{failZones: map[string]bool{}}
+}
+
+// InMemoryFailEveryNthApply makes every nth call to ApplyChanges fail without applying any change
+func InMemoryFailEveryNthApply(n int) InMemoryOption {
+	return func(p *InMemoryProvider) {
+		p.faults.failEveryNthApply = n
+	}
+}
+
+// InMemoryFailZones makes ApplyChanges fail when it reaches the changes of one of the given zones
+func InMemoryFailZones(zones []string) InMemoryOption {
+	return func(p *InMemoryProvider) {
+		for _, z := range zones {
+			if z != "" {
+				p.faults.failZones[z] = true
+			}
+		}
+	}
+}
+
+// InMemoryThrottleEveryNthCall makes every nth call to Records or ApplyChanges fail with ErrThrottled
+func InMemoryThrottleEveryNthCall(n int) InMemoryOption {
+	return func(p *InMemoryProvider) {
+		p.faults.throttleEveryNthCall = n
+	}
+}
+
+// InMemoryWithLatency delays every call to Records or ApplyChanges by the given duration
+func InMemoryWithLatency(latency time.Duration) InMemoryOption {
+	return func(p *InMemoryProvider) {
+		p.faults.latency = latency
+	}
+}
+
+// InMemoryWithRecordsLag simulates eventual consistency, Records returns the records
+// as they were before the last n calls to ApplyChanges
+func InMemoryWithRecordsLag(n int) InMemoryOption {
+	return func(p *InMemoryProvider) {
+		p.faults.recordsLag = n
+	}
+}
+
+// beforeCall adds the configured latency and throttles the call if it is due
+func (f *faults) beforeCall(ctx context.Context) error {
+	if f.latency > 0 {
+		select {
+		case <-time.After(f.latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.callCount++
+	if f.throttleEveryNthCall > 0 && f.callCount%f.throttleEveryNthCall == 0 {
+		return ErrThrottled
+	}
+	return nil
+}
+
+// beforeApply fails the call to ApplyChanges if it is due
+func (f *faults) beforeApply() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.applyCount++
+	if f.failEveryNthApply > 0 && f.applyCount%f.failEveryNthApply == 0 {
+		return fmt.Errorf("%w: call %d to ApplyChanges", ErrInjectedFailure, f.applyCount)
+	}
+	return nil
+}
+
+// beforeZone fails applying the changes of the zone if configured
+func (f *faults) beforeZone(zoneID string) error {
+	if f.failZones[zoneID] {
+		return fmt.Errorf("%w: zone %s", ErrInjectedFailure, zoneID)
+	}
+	return nil
+}
+
+// recordHistory keeps the records as they were before a call to ApplyChanges
+func (f *faults) recordHistory(records map[string][]*endpoint.Endpoint) {
+	if f.recordsLag <= 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.history = append(f.history, records)
+	if len(f.history) > f.recordsLag {
+		f.history = f.history[len(f.history)-f.recordsLag:]
+	}
+}
+
+// laggingRecords returns the records Records should return instead of the current ones, if any
+func (f *faults) laggingRecords() (map[string][]*endpoint.Endpoint, bool) {
+	if f.recordsLag <= 0 {
+		return nil, false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.history) == 0 {
+		return nil, false
+	}
+	return f.history[0], true
+}
@@ -0,0 +1,93 @@
+package inmemory
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// InspectionHandler returns a read-only HTTP handler listing the zones and records held by the provider
+//
+//	GET /zones                  - map of zone ID to zone name
+//	GET /zones/<zoneID>/records - records of a single zone
+//	GET /records                - records of all zones
+func (im *InMemoryProvider) InspectionHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/zones", im.serveZones)
+	mux.HandleFunc("/zones/", im.serveZoneRecords)
+	mux.HandleFunc("/records", im.serveRecords)
+	return mux
+}
+
+func (im *InMemoryProvider) serveZones(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	writeJSON(w, im.Zones())
+}
+
+func (im *InMemoryProvider) serveZoneRecords(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	zoneID := strings.TrimPrefix(r.URL.Path, "/zones/")
+	if !strings.HasSuffix(zoneID, "/records") {
+		http.NotFound(w, r)
+		return
+	}
+	zoneID = strings.TrimSuffix(zoneID, "/records")
+	if _, ok := im.Zones()[zoneID]; !ok {
+		http.Error(w, ErrZoneNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	records, err := im.client.Records(zoneID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].String() < records[j].String()
+	})
+	writeJSON(w, records)
+}
+
+func (im *InMemoryProvider) serveRecords(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	// read the client directly, so that inspection is not subject to injected faults
+	records := []*endpoint.Endpoint{}
+	for zoneID := range im.Zones() {
+		zoneRecords, err := im.client.Records(zoneID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		records = append(records, zoneRecords...)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].String() < records[j].String()
+	})
+	writeJSON(w, records)
+}
+
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("failed to encode inmemory inspection response: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
@@ -23,6 +24,8 @@ var (
 	ErrRecordNotFound = errors.New("record not found")
 	// ErrDuplicateRecordFound when record is repeated in create/update/delete
 	ErrDuplicateRecordFound = errors.New("invalid batch request")
+	// ErrCNAMEConflict when a batch would leave a CNAME record next to records of other types with the same name
+	ErrCNAMEConflict = errors.New("CNAME record conflicts with another record of the same name")
 )
 
 // InMemoryProvider - dns provider only used for testing purposes
@@ -34,6 +37,14 @@ type InMemoryProvider struct {
 	filter         *filter
 	OnApplyChanges func(ctx context.Context, changes *plan.Changes)
 	OnRecords      func()
+	// snapshotPath is the file the zones and records are persisted to, if set
+	snapshotPath string
+	faults       *faults
+	// snapshotErr is the error restoring the snapshot file, which is then left untouched
+	snapshotErr error
+	// writeMu serializes the calls modifying the provider state, so that the lagging
+	// records and the snapshot file follow the order in which changes are applied
+	writeMu sync.Mutex
 }
 
 // InMemoryOption allows to extend in-memory provider
@@ -55,6 +66,9 @@ func InMemoryWithLogging() InMemoryOption {
 			for _, v := range changes.Delete {
 				log.Infof("DELETE: %v", v)
 			}
+			for i, v := range changes.ReplaceNew {
+				log.Infof("REPLACE: %v -> %v", changes.ReplaceOld[i], v)
+			}
 		}
 	}
 }
@@ -70,8 +84,8 @@ func InMemoryWithDomain(domainFilter endpoint.DomainFilter) InMemoryOption {
 func InMemoryInitZones(zones []string) InMemoryOption {
 	return func(p *InMemoryProvider) {
 		for _, z := range zones {
-			if err := p.CreateZone(z); err != nil {
-				log.Warnf("Unable to initialize zones for inmemory provider")
+			if err := p.CreateZone(z); err != nil && err != ErrZoneAlreadyExists {
+				log.Warnf("Unable to initialize zones for inmemory provider: %v", err)
 			}
 		}
 	}
@@ -85,6 +99,7 @@ func NewInMemoryProvider(opts ...InMemoryOption) *InMemoryProvider {
 		OnRecords:      func() {},
 		domain:         endpoint.NewDomainFilter([]string{""}),
 		client:         newInMemoryClient(),
+		faults:         newFaults(),
 	}
 
 	for _, opt := range opts {
@@ -96,7 +111,14 @@ func NewInMemoryProvider(opts ...InMemoryOption) *InMemoryProvider {
 
 // CreateZone adds new zone if not present
 func (im *InMemoryProvider) CreateZone(newZone string) error {
-	return im.client.CreateZone(newZone)
+	im.writeMu.Lock()
+	defer im.writeMu.Unlock()
+
+	if err := im.client.CreateZone(newZone); err != nil {
+		return err
+	}
+	im.persist()
+	return nil
 }
 
 // Zones returns filtered zones as specified by domain
@@ -108,18 +130,29 @@ func (im *InMemoryProvider) Zones() map[string]string {
 func (im *InMemoryProvider) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
 	defer im.OnRecords()
 
-	endpoints := make([]*endpoint.Endpoint, 0)
+	if err := im.faults.beforeCall(ctx); err != nil {
+		return nil, err
+	}
 
-	for zoneID := range im.Zones() {
-		records, err := im.client.Records(zoneID)
-		if err != nil {
-			return nil, err
-		}
+	// read all zones at once, so that the records are consistent even while changes are applied
+	state, lagging := im.faults.laggingRecords()
+	if !lagging {
+		state = im.client.snapshot()
+	}
+	zones := map[string]string{}
+	for zoneID := range state {
+		zones[zoneID] = zoneID
+	}
 
-		for _, record := range records {
-			ep := endpoint.NewEndpoint(record.Name, record.Type, record.Target).WithSetIdentifier(record.SetIdentifier)
-			ep.Labels = record.Labels
-			endpoints = append(endpoints, ep)
+	endpoints := make([]*endpoint.Endpoint, 0)
+
+	for zoneID := range im.filter.Zones(zones) {
+		for _, rec := range state[zoneID] {
+			if lagging {
+				// the history is shared between calls
+				rec = rec.DeepCopy()
+			}
+			endpoints = append(endpoints, rec)
 		}
 	}
 
@@ -131,9 +164,22 @@ func (im *InMemoryProvider) Records(ctx context.Context) ([]*endpoint.Endpoint,
 // create record - record should not exist
 // update/delete record - record should exist
 // create/update/delete lists should not have overlapping records
+// records are identified by name, type and set identifier, and a CNAME record cannot share its name with other records
 func (im *InMemoryProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
 	defer im.OnApplyChanges(ctx, changes)
 
+	if err := im.faults.beforeCall(ctx); err != nil {
+		return err
+	}
+
+	im.writeMu.Lock()
+	defer im.writeMu.Unlock()
+
+	if err := im.faults.beforeApply(); err != nil {
+		return err
+	}
+	im.faults.recordHistory(im.client.snapshot())
+
 	perZoneChanges := map[string]*plan.Changes{}
 
 	zones := im.Zones()
@@ -169,35 +215,21 @@ func (im *InMemoryProvider) ApplyChanges(ctx context.Context, changes *plan.Chan
 		}
 		perZoneChanges[zoneID].Delete = append(perZoneChanges[zoneID].Delete, ep)
 	}
-
-	for zoneID := range perZoneChanges {
-		change := &inMemoryChange{
-			Create:    convertToInMemoryRecord(perZoneChanges[zoneID].Create),
-			UpdateNew: convertToInMemoryRecord(perZoneChanges[zoneID].UpdateNew),
-			UpdateOld: convertToInMemoryRecord(perZoneChanges[zoneID].UpdateOld),
-			Delete:    convertToInMemoryRecord(perZoneChanges[zoneID].Delete),
-		}
-		err := im.client.ApplyChanges(ctx, zoneID, change)
-		if err != nil {
-			return err
+	for i, ep := range changes.ReplaceNew {
+		zoneID := im.filter.EndpointZoneID(ep, zones)
+		if zoneID == "" {
+			continue
 		}
+		perZoneChanges[zoneID].ReplaceOld = append(perZoneChanges[zoneID].ReplaceOld, changes.ReplaceOld[i])
+		perZoneChanges[zoneID].ReplaceNew = append(perZoneChanges[zoneID].ReplaceNew, ep)
 	}
 
-	return nil
-}
+	if err := im.client.ApplyChanges(ctx, perZoneChanges, im.faults.beforeZone); err != nil {
+		return err
+	}
 
-func convertToInMemoryRecord(endpoints []*endpoint.Endpoint) []*inMemoryRecord {
-	records := []*inMemoryRecord{}
-	for _, ep := range endpoints {
-		records = append(records, &inMemoryRecord{
-			Type:          ep.RecordType,
-			Name:          ep.DNSName,
-			Target:        ep.Targets[0],
-			SetIdentifier: ep.SetIdentifier,
-			Labels:        ep.Labels,
-		})
-	}
-	return records
+	im.persist()
+	return nil
 }
 
 type filter struct {
@@ -228,48 +260,55 @@ func (f *filter) EndpointZoneID(endpoint *endpoint.Endpoint, zones map[string]st
 	return matchZoneID
 }
 
-// inMemoryRecord - record stored in memory
-// Type - type of record
-// Name - DNS name assigned to the record
-// Target - target of the record
-type inMemoryRecord struct {
+// recordKey identifies a record within a zone, like a record set does on a real DNS provider
+type recordKey struct {
+	Name          string
 	Type          string
 	SetIdentifier string
-	Name          string
-	Target        string
-	Labels        endpoint.Labels
 }
 
-type zone map[string][]*inMemoryRecord
-
-type inMemoryChange struct {
-	Create    []*inMemoryRecord
-	UpdateNew []*inMemoryRecord
-	UpdateOld []*inMemoryRecord
-	Delete    []*inMemoryRecord
+func newRecordKey(ep *endpoint.Endpoint) recordKey {
+	return recordKey{Name: ep.DNSName, Type: ep.RecordType, SetIdentifier: ep.SetIdentifier}
 }
 
+// zone holds copies of the endpoints stored in memory, with all their targets, TTL,
+// labels and provider specific properties
+type zone map[recordKey]*endpoint.Endpoint
+
+// inMemoryClient is safe for concurrent use, stored endpoints are never modified
+// but replaced, so readers only need to hold the lock while copying them
 type inMemoryClient struct {
+	mu    sync.RWMutex
 	zones map[string]zone
 }
 
 func newInMemoryClient() *inMemoryClient {
-	return &inMemoryClient{map[string]zone{}}
+	return &inMemoryClient{zones: map[string]zone{}}
+}
+
+func (c *inMemoryClient) Records(zone string) ([]*endpoint.Endpoint, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.records(zone)
 }
 
-func (c *inMemoryClient) Records(zone string) ([]*inMemoryRecord, error) {
+func (c *inMemoryClient) records(zone string) ([]*endpoint.Endpoint, error) {
 	if _, ok := c.zones[zone]; !ok {
 		return nil, ErrZoneNotFound
 	}
 
-	records := []*inMemoryRecord{}
+	records := []*endpoint.Endpoint{}
 	for _, rec := range c.zones[zone] {
-		records = append(records, rec...)
+		records = append(records, rec.DeepCopy())
 	}
 	return records, nil
 }
 
 func (c *inMemoryClient) Zones() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	zones := map[string]string{}
 	for zone := range c.zones {
 		zones[zone] = zone
@@ -277,70 +316,119 @@ func (c *inMemoryClient) Zones() map[string]string {
 	return zones
 }
 
-func (c *inMemoryClient) CreateZone(zone string) error {
-	if _, ok := c.zones[zone]; ok {
+func (c *inMemoryClient) CreateZone(zoneID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.zones[zoneID]; ok {
 		return ErrZoneAlreadyExists
 	}
-	c.zones[zone] = map[string][]*inMemoryRecord{}
+	c.zones[zoneID] = zone{}
 
 	return nil
 }
 
-func (c *inMemoryClient) ApplyChanges(ctx context.Context, zoneID string, changes *inMemoryChange) error {
-	if err := c.validateChangeBatch(zoneID, changes); err != nil {
-		return err
+// snapshot returns copies of the records of all zones, taken at a single point in time
+func (c *inMemoryClient) snapshot() map[string][]*endpoint.Endpoint {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	zones := map[string][]*endpoint.Endpoint{}
+	for zoneID := range c.zones {
+		zones[zoneID], _ = c.records(zoneID)
 	}
-	for _, newEndpoint := range changes.Create {
-		if _, ok := c.zones[zoneID][newEndpoint.Name]; !ok {
-			c.zones[zoneID][newEndpoint.Name] = make([]*inMemoryRecord, 0)
+	return zones
+}
+
+// restore replaces all zones and records with the given ones
+func (c *inMemoryClient) restore(zones map[string][]*endpoint.Endpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.zones = map[string]zone{}
+	for zoneID, records := range zones {
+		z := zone{}
+		for _, rec := range records {
+			z[newRecordKey(rec)] = rec
 		}
-		c.zones[zoneID][newEndpoint.Name] = append(c.zones[zoneID][newEndpoint.Name], newEndpoint)
+		c.zones[zoneID] = z
 	}
-	for _, updateEndpoint := range changes.UpdateNew {
-		for _, rec := range c.zones[zoneID][updateEndpoint.Name] {
-			if rec.Type == updateEndpoint.Type {
-				rec.Target = updateEndpoint.Target
-				break
-			}
+}
+
+// ApplyChanges applies the changes of each zone while holding the lock, so that readers never
+// observe part of them. The changes of every zone are validated before any zone is changed, so
+// an invalid batch changes nothing. beforeZone is called before the changes of each zone are
+// applied and stops the application if it returns an error, which leaves the zones applied
+// before in place, as a provider failing midway would.
+func (c *inMemoryClient) ApplyChanges(ctx context.Context, perZoneChanges map[string]*plan.Changes, beforeZone func(zoneID string) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	updated := map[string]zone{}
+	for zoneID, changes := range perZoneChanges {
+		z, err := c.zoneWithChanges(zoneID, changes)
+		if err != nil {
+			return err
 		}
+		updated[zoneID] = z
 	}
-	for _, deleteEndpoint := range changes.Delete {
-		newSet := make([]*inMemoryRecord, 0)
-		for _, rec := range c.zones[zoneID][deleteEndpoint.Name] {
-			if rec.Type != deleteEndpoint.Type {
-				newSet = append(newSet, rec)
-			}
+	for zoneID, z := range updated {
+		if err := beforeZone(zoneID); err != nil {
+			return err
 		}
-		c.zones[zoneID][deleteEndpoint.Name] = newSet
+		c.zones[zoneID] = z
 	}
 	return nil
 }
 
-func (c *inMemoryClient) updateMesh(mesh map[string]map[string]map[string]bool, record *inMemoryRecord) error {
-	if _, exists := mesh[record.Name]; exists {
-		if _, exists := mesh[record.Name][record.Type]; exists {
-			if mesh[record.Name][record.Type][record.SetIdentifier] {
-				return ErrDuplicateRecordFound
-			}
-			mesh[record.Name][record.Type][record.SetIdentifier] = true
-			return nil
-		}
-		mesh[record.Name][record.Type] = map[string]bool{record.SetIdentifier: true}
-		return nil
+// zoneWithChanges returns a copy of the zone with the changes applied, if the whole batch is valid
+func (c *inMemoryClient) zoneWithChanges(zoneID string, changes *plan.Changes) (zone, error) {
+	if err := c.validateChangeBatch(zoneID, changes); err != nil {
+		return nil, err
+	}
+	updated := make(zone, len(c.zones[zoneID]))
+	for key, rec := range c.zones[zoneID] {
+		updated[key] = rec
+	}
+	for _, deleteEndpoint := range changes.Delete {
+		delete(updated, newRecordKey(deleteEndpoint))
+	}
+	for _, replaceOldEndpoint := range changes.ReplaceOld {
+		delete(updated, newRecordKey(replaceOldEndpoint))
+	}
+	for _, replaceNewEndpoint := range changes.ReplaceNew {
+		updated[newRecordKey(replaceNewEndpoint)] = replaceNewEndpoint.DeepCopy()
+	}
+	for _, updateEndpoint := range changes.UpdateNew {
+		updated[newRecordKey(updateEndpoint)] = updateEndpoint.DeepCopy()
+	}
+	for _, newEndpoint := range changes.Create {
+		updated[newRecordKey(newEndpoint)] = newEndpoint.DeepCopy()
+	}
+	if err := validateCNAMEExclusivity(updated, changes); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (c *inMemoryClient) updateMesh(mesh map[recordKey]bool, record *endpoint.Endpoint) error {
+	key := newRecordKey(record)
+	if mesh[key] {
+		return ErrDuplicateRecordFound
 	}
-	mesh[record.Name] = map[string]map[string]bool{record.Type: {record.SetIdentifier: true}}
+	mesh[key] = true
 	return nil
 }
 
 // validateChangeBatch validates that the changes passed to InMemory DNS provider is valid
-func (c *inMemoryClient) validateChangeBatch(zone string, changes *inMemoryChange) error {
+func (c *inMemoryClient) validateChangeBatch(zone string, changes *plan.Changes) error {
 	curZone, ok := c.zones[zone]
 	if !ok {
 		return ErrZoneNotFound
 	}
-	mesh := map[string]map[string]map[string]bool{}
+	mesh := map[recordKey]bool{}
 	for _, newEndpoint := range changes.Create {
-		if c.findByTypeAndSetIdentifier(newEndpoint.Type, newEndpoint.SetIdentifier, curZone[newEndpoint.Name]) != nil {
+		if _, ok := curZone[newRecordKey(newEndpoint)]; ok {
 			return ErrRecordAlreadyExists
 		}
 		if err := c.updateMesh(mesh, newEndpoint); err != nil {
@@ -348,7 +436,7 @@ func (c *inMemoryClient) validateChangeBatch(zone string, changes *inMemoryChang
 		}
 	}
 	for _, updateEndpoint := range changes.UpdateNew {
-		if c.findByTypeAndSetIdentifier(updateEndpoint.Type, updateEndpoint.SetIdentifier, curZone[updateEndpoint.Name]) == nil {
+		if _, ok := curZone[newRecordKey(updateEndpoint)]; !ok {
 			return ErrRecordNotFound
 		}
 		if err := c.updateMesh(mesh, updateEndpoint); err != nil {
@@ -356,25 +444,61 @@ func (c *inMemoryClient) validateChangeBatch(zone string, changes *inMemoryChang
 		}
 	}
 	for _, updateOldEndpoint := range changes.UpdateOld {
-		if rec := c.findByTypeAndSetIdentifier(updateOldEndpoint.Type, updateOldEndpoint.SetIdentifier, curZone[updateOldEndpoint.Name]); rec == nil || rec.Target != updateOldEndpoint.Target {
+		if rec, ok := curZone[newRecordKey(updateOldEndpoint)]; !ok || !rec.Targets.Same(updateOldEndpoint.Targets) {
 			return ErrRecordNotFound
 		}
 	}
 	for _, deleteEndpoint := range changes.Delete {
-		if rec := c.findByTypeAndSetIdentifier(deleteEndpoint.Type, deleteEndpoint.SetIdentifier, curZone[deleteEndpoint.Name]); rec == nil || rec.Target != deleteEndpoint.Target {
+		if rec, ok := curZone[newRecordKey(deleteEndpoint)]; !ok || !rec.Targets.Same(deleteEndpoint.Targets) {
 			return ErrRecordNotFound
 		}
 		if err := c.updateMesh(mesh, deleteEndpoint); err != nil {
 			return err
 		}
 	}
+	for _, replaceOldEndpoint := range changes.ReplaceOld {
+		if rec, ok := curZone[newRecordKey(replaceOldEndpoint)]; !ok || !rec.Targets.Same(replaceOldEndpoint.Targets) {
+			return ErrRecordNotFound
+		}
+		if err := c.updateMesh(mesh, replaceOldEndpoint); err != nil {
+			return err
+		}
+	}
+	for _, replaceNewEndpoint := range changes.ReplaceNew {
+		if _, ok := curZone[newRecordKey(replaceNewEndpoint)]; ok {
+			return ErrRecordAlreadyExists
+		}
+		if err := c.updateMesh(mesh, replaceNewEndpoint); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (c *inMemoryClient) findByTypeAndSetIdentifier(recordType, setIdentifier string, records []*inMemoryRecord) *inMemoryRecord {
-	for _, record := range records {
-		if record.Type == recordType && record.SetIdentifier == setIdentifier {
-			return record
+// validateCNAMEExclusivity makes sure that no name the changes add records to holds a CNAME
+// record next to records of other types, see RFC 1034 section 3.6.2. Conflicts of other names
+// are left alone, so that they do not block unrelated changes.
+func validateCNAMEExclusivity(z zone, changes *plan.Changes) error {
+	touched := map[string]bool{}
+	for _, eps := range [][]*endpoint.Endpoint{changes.Create, changes.UpdateNew, changes.ReplaceNew} {
+		for _, ep := range eps {
+			touched[newRecordKey(ep).Name] = true
+		}
+	}
+	types := map[string]map[string]bool{}
+	for key := range z {
+		if !touched[key.Name] {
+			continue
+		}
+		if _, ok := types[key.Name]; !ok {
+			types[key.Name] = map[string]bool{}
+		}
+		types[key.Name][key.Type] = true
+	}
+	for name, t := range types {
+		if t[endpoint.RecordTypeCNAME] && len(t) > 1 {
+			log.Debugf("CNAME record %s conflicts with records of other types", name)
+			return ErrCNAMEConflict
 		}
 	}
 	return nil
@@ -0,0 +1,271 @@
+package inmemory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/toppr-systems/dops/endpoint"
+	"github.com/toppr-systems/dops/plan"
+)
+
+func create(names ...string) *plan.Changes {
+	changes := &plan.Changes{}
+	for _, name := range names {
+		changes.Create = append(changes.Create, endpoint.NewEndpoint(name, endpoint.RecordTypeA, "192.0.2.1"))
+	}
+	return changes
+}
+
+func recordNames(t *testing.T, p *InMemoryProvider) map[string]bool {
+	t.Helper()
+	records, err := p.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, ep := range records {
+		names[ep.DNSName] = true
+	}
+	return names
+}
+
+func TestInMemoryProviderConcurrentAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	p := NewInMemoryProvider(InMemoryInitZones([]string{"example.com", "example.org"}), InMemoryWithSnapshot(path))
+	handler := p.InspectionHandler()
+
+	const writers = 20
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("host-%d.example.com", i)
+			if err := p.ApplyChanges(ctx, create(name, fmt.Sprintf("host-%d.example.org", i))); err != nil {
+				t.Error(err)
+				return
+			}
+			update := &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint(name, endpoint.RecordTypeA, "192.0.2.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint(name, endpoint.RecordTypeA, "192.0.2.2")},
+			}
+			if err := p.ApplyChanges(ctx, update); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			records, err := p.Records(ctx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// a record of one zone is never seen without the record of the other zone
+			names := map[string]bool{}
+			for _, ep := range records {
+				names[ep.DNSName] = true
+			}
+			for j := 0; j < writers; j++ {
+				if names[fmt.Sprintf("host-%d.example.com", j)] != names[fmt.Sprintf("host-%d.example.org", j)] {
+					t.Errorf("changes of host-%d observed partially", j)
+				}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/records", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("inspection returned %d", rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if names := recordNames(t, p); len(names) != 2*writers {
+		t.Errorf("expected %d records, got %d", 2*writers, len(names))
+	}
+	restored := NewInMemoryProvider(InMemoryWithSnapshot(path))
+	records, err := restored.Records(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2*writers {
+		t.Errorf("expected %d restored records, got %d", 2*writers, len(records))
+	}
+	for _, ep := range records {
+		if strings.HasSuffix(ep.DNSName, ".example.com") && ep.Targets[0] != "192.0.2.2" {
+			t.Errorf("expected the update of %s to be restored, got %v", ep.DNSName, ep.Targets)
+		}
+	}
+}
+
+func TestInMemoryProviderInvalidBatchChangesNothing(t *testing.T) {
+	// the zones are applied in random order, repeat to cover both
+	for i := 0; i < 20; i++ {
+		p := NewInMemoryProvider(InMemoryInitZones([]string{"example.com", "example.org"}))
+		changes := create("foo.example.com")
+		changes.Delete = []*endpoint.Endpoint{endpoint.NewEndpoint("missing.example.org", endpoint.RecordTypeA, "192.0.2.1")}
+		if err := p.ApplyChanges(context.Background(), changes); err != ErrRecordNotFound {
+			t.Fatalf("expected %v, got %v", ErrRecordNotFound, err)
+		}
+		if names := recordNames(t, p); len(names) != 0 {
+			t.Fatalf("expected no records, got %v", names)
+		}
+	}
+}
+
+func TestInMemoryProviderConcurrentFaults(t *testing.T) {
+	p := NewInMemoryProvider(InMemoryInitZones([]string{"example.com"}), InMemoryFailEveryNthApply(2))
+
+	const writers = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			err := p.ApplyChanges(context.Background(), create(fmt.Sprintf("host-%d.example.com", i)))
+			if err != nil && !errors.Is(err, ErrInjectedFailure) {
+				t.Error(err)
+			}
+			if err != nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Records(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed != writers/2 {
+		t.Errorf("expected %d injected failures, got %d", writers/2, failed)
+	}
+	if names := recordNames(t, p); len(names) != writers-failed {
+		t.Errorf("expected %d records, got %d", writers-failed, len(names))
+	}
+}
+
+func TestInMemoryProviderThrottling(t *testing.T) {
+	p := NewInMemoryProvider(InMemoryInitZones([]string{"example.com"}), InMemoryThrottleEveryNthCall(3))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	throttled := 0
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Records(context.Background()); err == ErrThrottled {
+				mu.Lock()
+				throttled++
+				mu.Unlock()
+			} else if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if throttled != 10 {
+		t.Errorf("expected 10 throttled calls, got %d", throttled)
+	}
+}
+
+func TestInMemoryProviderFailZones(t *testing.T) {
+	p := NewInMemoryProvider(InMemoryInitZones([]string{"example.com", "example.org"}), InMemoryFailZones([]string{"example.org"}))
+
+	err := p.ApplyChanges(context.Background(), create("foo.example.com", "foo.example.org"))
+	if !errors.Is(err, ErrInjectedFailure) {
+		t.Fatalf("expected %v, got %v", ErrInjectedFailure, err)
+	}
+	if names := recordNames(t, p); names["foo.example.org"] {
+		t.Errorf("expected the changes of the failed zone not to be applied, got %v", names)
+	}
+}
+
+func TestInMemoryProviderRecordsLag(t *testing.T) {
+	p := NewInMemoryProvider(InMemoryInitZones([]string{"example.com"}), InMemoryWithRecordsLag(1))
+
+	if err := p.ApplyChanges(context.Background(), create("foo.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if names := recordNames(t, p); len(names) != 0 {
+		t.Errorf("expected the records before the last change, got %v", names)
+	}
+	if err := p.ApplyChanges(context.Background(), create("bar.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if names := recordNames(t, p); len(names) != 1 || !names["foo.example.com"] {
+		t.Errorf("expected only foo.example.com, got %v", names)
+	}
+}
+
+func TestInMemoryProviderKeepsBrokenSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := ioutil.WriteFile(path, []byte(`{"zones":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := NewInMemoryProvider(InMemoryWithSnapshot(path), InMemoryInitZones([]string{"example.com"}))
+	if p.SnapshotError() == nil {
+		t.Fatal("expected the snapshot to fail to load")
+	}
+
+	if err := p.ApplyChanges(context.Background(), create("foo.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"zones":` {
+		t.Errorf("expected the snapshot to be left untouched, got %s", data)
+	}
+}
+
+func TestInMemoryProviderSnapshotFailureKeepsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "snapshot.json")
+	p := NewInMemoryProvider(InMemoryWithSnapshot(path), InMemoryInitZones([]string{"example.com"}))
+	if err := p.SnapshotError(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the changes are applied even though they can not be persisted
+	if err := p.ApplyChanges(context.Background(), create("foo.example.com")); err != nil {
+		t.Fatalf("expected the failure to persist the changes not to fail them, got %v", err)
+	}
+	if names := recordNames(t, p); !names["foo.example.com"] {
+		t.Errorf("expected foo.example.com to be applied, got %v", names)
+	}
+}
+
+func TestInMemoryProviderExistingCNAMEConflict(t *testing.T) {
+	p := NewInMemoryProvider()
+	// a conflict restored from a snapshot
+	p.client.restore(map[string][]*endpoint.Endpoint{"example.com": {
+		endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeCNAME, "bar.example.com"),
+		endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.1"),
+	}})
+
+	if err := p.ApplyChanges(context.Background(), create("bar.example.com")); err != nil {
+		t.Errorf("expected the changes of other names to be applied, got %v", err)
+	}
+	changes := &plan.Changes{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeAAAA, "2001:db8::1")}}
+	if err := p.ApplyChanges(context.Background(), changes); err != ErrCNAMEConflict {
+		t.Errorf("expected %v, got %v", ErrCNAMEConflict, err)
+	}
+}
@@ -0,0 +1,97 @@
+package inmemory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// snapshot is the on-disk representation of the in-memory provider state
+type snapshot struct {
+	// Zones maps each zone to its records
+	Zones map[string][]*endpoint.Endpoint `json:"zones"`
+}
+
+// InMemoryWithSnapshot persists the zones and records of the InMemoryProvider as JSON
+// to the given file after every change, and restores them from it if the file exists.
+// A file which fails to load is never overwritten, see SnapshotError.
+func InMemoryWithSnapshot(path string) InMemoryOption {
+	return func(p *InMemoryProvider) {
+		p.snapshotPath = path
+		if err := p.loadSnapshot(); err != nil {
+			p.snapshotErr = fmt.Errorf("unable to restore inmemory provider snapshot from %s: %v", path, err)
+		}
+	}
+}
+
+// SnapshotError returns the error restoring the snapshot file, if it failed to load
+func (im *InMemoryProvider) SnapshotError() error {
+	return im.snapshotErr
+}
+
+// loadSnapshot restores the zones and records from the snapshot file, a missing file is not an error
+func (im *InMemoryProvider) loadSnapshot() error {
+	data, err := ioutil.ReadFile(im.snapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s := snapshot{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	im.client.restore(s.Zones)
+	log.Infof("Restored %d zone(s) from inmemory provider snapshot %s", len(s.Zones), im.snapshotPath)
+	return nil
+}
+
+// persist saves the snapshot after the zones or records changed. The changes are applied
+// by then, so a failure to save them is logged instead of failing the changes.
+func (im *InMemoryProvider) persist() {
+	if err := im.saveSnapshot(); err != nil {
+		log.Errorf("Failed to persist the inmemory provider snapshot: %v", err)
+	}
+}
+
+// saveSnapshot writes the zones and records to the snapshot file, if one is configured.
+// The file is replaced atomically so that a crash never leaves a partial snapshot behind.
+func (im *InMemoryProvider) saveSnapshot() error {
+	if im.snapshotPath == "" {
+		return nil
+	}
+	if im.snapshotErr != nil {
+		return fmt.Errorf("refusing to overwrite snapshot %s which failed to load", im.snapshotPath)
+	}
+
+	data, err := json.MarshalIndent(snapshot{Zones: im.client.snapshot()}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(im.snapshotPath), filepath.Base(im.snapshotPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), im.snapshotPath); err != nil {
+		return fmt.Errorf("failed to replace snapshot %s: %v", im.snapshotPath, err)
+	}
+	return nil
+}
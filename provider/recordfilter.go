@@ -1,10 +1,10 @@
 package provider
 
 // SupportedRecordType returns true only for supported record types.
-// Currently A, CNAME, SRV, TXT and NS record types are supported.
+// Currently A, AAAA, CNAME, SRV, TXT, NS, MX and CAA record types are supported.
 func SupportedRecordType(recordType string) bool {
 	switch recordType {
-	case "A", "CNAME", "SRV", "TXT", "NS":
+	case "A", "AAAA", "CNAME", "SRV", "TXT", "NS", "MX", "CAA":
 		return true
 	default:
 		return false
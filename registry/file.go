@@ -0,0 +1,293 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/toppr-systems/dops/endpoint"
+	"github.com/toppr-systems/dops/plan"
+	"github.com/toppr-systems/dops/provider"
+)
+
+// FileRegistry implements registry interface with ownership kept in a local state file,
+// for providers and zones which can not hold the ownership TXT records
+type FileRegistry struct {
+	provider provider.Provider
+	ownerID  string //refers to the owner id of the current instance
+	path     string
+	// dryRun keeps the state file unchanged, the provider only logs the changes
+	dryRun bool
+
+	mu sync.Mutex
+	// owned holds the labels of the owned records, keyed by ownershipKey
+	owned map[string]FileRecord
+}
+
+// FileRecord is an owned record in the state file
+type FileRecord struct {
+	DNSName       string          `json:"dnsName"`
+	RecordType    string          `json:"recordType"`
+	SetIdentifier string          `json:"setIdentifier,omitempty"`
+	Labels        endpoint.Labels `json:"labels"`
+}
+
+// fileState is the on-disk representation of the FileRegistry state
+type fileState struct {
+	Version int          `json:"version"`
+	OwnerID string       `json:"ownerId"`
+	Records []FileRecord `json:"records"`
+}
+
+const fileStateVersion = 1
+
+// NewFileRegistry returns new FileRegistry object, restoring the state from the given file if it exists
+func NewFileRegistry(provider provider.Provider, ownerID, path string, dryRun bool) (*FileRegistry, error) {
+	if ownerID == "" {
+		return nil, errors.New("owner id cannot be empty")
+	}
+	if path == "" {
+		return nil, errors.New("state file cannot be empty")
+	}
+
+	im := &FileRegistry{
+		provider: provider,
+		ownerID:  ownerID,
+		path:     path,
+		dryRun:   dryRun,
+		owned:    map[string]FileRecord{},
+	}
+	if err := im.load(); err != nil {
+		return nil, fmt.Errorf("failed to read registry state %s: %v", path, err)
+	}
+	return im, nil
+}
+
+func (im *FileRegistry) GetDomainFilter() endpoint.DomainFilterInterface {
+	return im.provider.GetDomainFilter()
+}
+
+// Exists returns false if the state file has not been written yet
+func (im *FileRegistry) Exists() bool {
+	_, err := os.Stat(im.path)
+	return err == nil
+}
+
+// Records returns the current records from the dns provider, the owned ones with the labels of the state file.
+// The ownership of records which no longer exist, e.g. after a manual deletion, is forgotten, so that a
+// record created by hand later under the same name is not taken for an owned one.
+func (im *FileRegistry) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	records, err := im.provider.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	seen := map[string]bool{}
+	for _, ep := range records {
+		if ep.Labels == nil {
+			ep.Labels = endpoint.NewLabels()
+		}
+		seen[ownershipKey(ep)] = true
+		if rec, ok := im.owned[ownershipKey(ep)]; ok {
+			for k, v := range rec.Labels {
+				ep.Labels[k] = v
+			}
+		}
+	}
+
+	// records outside of the domain filter are not returned by the provider and are kept
+	domainFilter := im.provider.GetDomainFilter()
+	pruned := 0
+	for key, rec := range im.owned {
+		if seen[key] || !domainFilter.Match(rec.DNSName) {
+			continue
+		}
+		log.Infof("Forgetting the ownership of %s %s, the record no longer exists", rec.DNSName, rec.RecordType)
+		delete(im.owned, key)
+		pruned++
+	}
+	if pruned > 0 {
+		if err := im.save(); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// ApplyChanges propagates the changes to the dns provider and records the ownership in the state file.
+// The created records are recorded before the changes are applied and the deleted ones are forgotten
+// once the changes succeeded, so that a failure never leaves a record behind without its owner.
+func (im *FileRegistry) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+	filteredChanges := &plan.Changes{
+		Create:    changes.Create,
+		UpdateNew: filterOwnedRecords(im.ownerID, changes.UpdateNew),
+		UpdateOld: filterOwnedRecords(im.ownerID, changes.UpdateOld),
+		Delete:    filterOwnedRecords(im.ownerID, changes.Delete),
+	}
+	filteredChanges.ReplaceOld, filteredChanges.ReplaceNew = filterOwnedReplacements(im.ownerID, changes.ReplaceOld, changes.ReplaceNew)
+	for _, r := range filteredChanges.Create {
+		if r.Labels == nil {
+			r.Labels = make(map[string]string)
+		}
+		r.Labels[endpoint.OwnerLabelKey] = im.ownerID
+	}
+	if im.dryRun {
+		return im.provider.ApplyChanges(ctx, filteredChanges)
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, eps := range [][]*endpoint.Endpoint{filteredChanges.Create, filteredChanges.UpdateNew, filteredChanges.ReplaceNew} {
+		for _, r := range eps {
+			im.own(r)
+		}
+	}
+	if err := im.save(); err != nil {
+		return err
+	}
+
+	if err := im.provider.ApplyChanges(ctx, filteredChanges); err != nil {
+		return err
+	}
+
+	for _, eps := range [][]*endpoint.Endpoint{filteredChanges.Delete, filteredChanges.ReplaceOld} {
+		for _, r := range eps {
+			delete(im.owned, ownershipKey(r))
+		}
+	}
+	return im.save()
+}
+
+// Import records the ownership of the records the given registry reports as owned by this
+// instance, e.g. a TXTRegistry with the same owner id, and returns the number of records imported
+func (im *FileRegistry) Import(ctx context.Context, from Registry) (int, error) {
+	records, err := from.Records(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	imported := 0
+	for _, ep := range records {
+		if ep.Labels[endpoint.OwnerLabelKey] != im.ownerID {
+			continue
+		}
+		im.own(ep)
+		imported++
+	}
+	return imported, im.save()
+}
+
+func (im *FileRegistry) own(ep *endpoint.Endpoint) {
+	labels := endpoint.NewLabels()
+	for k, v := range ep.Labels {
+		labels[k] = v
+	}
+	im.owned[ownershipKey(ep)] = FileRecord{
+		DNSName:       strings.ToLower(ep.DNSName),
+		RecordType:    ep.RecordType,
+		SetIdentifier: ep.SetIdentifier,
+		Labels:        labels,
+	}
+}
+
+// load restores the owned records from the state file, a missing file is not an error
+func (im *FileRegistry) load() error {
+	data, err := ioutil.ReadFile(im.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	state := fileState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.Version != fileStateVersion {
+		return fmt.Errorf("unsupported state version %d", state.Version)
+	}
+	if state.OwnerID != im.ownerID {
+		return fmt.Errorf("state belongs to owner %q, not %q", state.OwnerID, im.ownerID)
+	}
+	for _, rec := range state.Records {
+		im.owned[labelKey(rec.DNSName, rec.SetIdentifier, rec.RecordType)] = rec
+	}
+	log.Infof("Restored the ownership of %d record(s) from %s", len(state.Records), im.path)
+	return nil
+}
+
+// save writes the owned records to the state file. The file is replaced atomically
+// so that a crash never leaves a partial state behind.
+func (im *FileRegistry) save() error {
+	if im.dryRun {
+		return nil
+	}
+	state := fileState{Version: fileStateVersion, OwnerID: im.ownerID, Records: []FileRecord{}}
+	for _, rec := range im.owned {
+		state.Records = append(state.Records, rec)
+	}
+	sort.Slice(state.Records, func(i, j int) bool {
+		a, b := state.Records[i], state.Records[j]
+		if a.DNSName != b.DNSName {
+			return a.DNSName < b.DNSName
+		}
+		if a.RecordType != b.RecordType {
+			return a.RecordType < b.RecordType
+		}
+		return a.SetIdentifier < b.SetIdentifier
+	})
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(im.path), filepath.Base(im.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), im.path); err != nil {
+		return fmt.Errorf("failed to replace registry state %s: %v", im.path, err)
+	}
+	return nil
+}
+
+// PropertyValuesEqual compares two property values for equality
+func (im *FileRegistry) PropertyValuesEqual(attribute string, previous string, current string) bool {
+	return im.provider.PropertyValuesEqual(attribute, previous, current)
+}
+
+// AdjustEndpoints modifies the endpoints as needed by the specific provider
+func (im *FileRegistry) AdjustEndpoints(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	return im.provider.AdjustEndpoints(endpoints)
+}
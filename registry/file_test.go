@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/toppr-systems/dops/endpoint"
+	"github.com/toppr-systems/dops/plan"
+)
+
+func fileOwners(t *testing.T, r *FileRegistry) map[string]string {
+	t.Helper()
+	records, err := r.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := map[string]string{}
+	for _, ep := range records {
+		owners[ep.DNSName+" "+ep.RecordType] = ep.Labels[endpoint.OwnerLabelKey]
+	}
+	return owners
+}
+
+func TestFileRegistryForgetsDeletedRecords(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+	p := newTestProvider(t)
+	r, err := NewFileRegistry(p, "owner", path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := &plan.Changes{Create: []*endpoint.Endpoint{
+		endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.1"),
+		endpoint.NewEndpoint("bar.example.com", endpoint.RecordTypeA, "192.0.2.2"),
+	}}
+	if err := r.ApplyChanges(ctx, changes); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"foo.example.com A": "owner", "bar.example.com A": "owner"}
+	if got := fileOwners(t, r); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected owners %v, got %v", expected, got)
+	}
+
+	// the record is deleted and created again by hand
+	deleted := &plan.Changes{Delete: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.1")}}
+	if err := p.ApplyChanges(ctx, deleted); err != nil {
+		t.Fatal(err)
+	}
+	fileOwners(t, r)
+	created := &plan.Changes{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.3")}}
+	if err := p.ApplyChanges(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = map[string]string{"foo.example.com A": "", "bar.example.com A": "owner"}
+	if got := fileOwners(t, r); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected owners %v, got %v", expected, got)
+	}
+	restored, err := NewFileRegistry(p, "owner", path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fileOwners(t, restored); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected restored owners %v, got %v", expected, got)
+	}
+}
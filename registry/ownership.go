@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// Ownership is a managed name with the owner and the resource of its records
+type Ownership struct {
+	DNSName     string   `json:"dnsName"`
+	Owner       string   `json:"owner"`
+	Resource    string   `json:"resource,omitempty"`
+	RecordTypes []string `json:"recordTypes"`
+}
+
+// ListOwnership groups the records with an owner by name, owner and resource, sorted by name
+func ListOwnership(records []*endpoint.Endpoint) []Ownership {
+	byKey := map[string]*Ownership{}
+	for _, ep := range records {
+		owner := ep.Labels[endpoint.OwnerLabelKey]
+		if owner == "" {
+			continue
+		}
+		o := Ownership{
+			DNSName:  strings.ToLower(ep.DNSName),
+			Owner:    owner,
+			Resource: ep.Labels[endpoint.ResourceLabelKey],
+		}
+		key := strings.Join([]string{o.DNSName, o.Owner, o.Resource}, " ")
+		if byKey[key] == nil {
+			byKey[key] = &o
+		}
+		if !containsString(byKey[key].RecordTypes, ep.RecordType) {
+			byKey[key].RecordTypes = append(byKey[key].RecordTypes, ep.RecordType)
+		}
+	}
+
+	list := []Ownership{}
+	for _, o := range byKey {
+		sort.Strings(o.RecordTypes)
+		list = append(list, *o)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.DNSName != b.DNSName {
+			return a.DNSName < b.DNSName
+		}
+		if a.Owner != b.Owner {
+			return a.Owner < b.Owner
+		}
+		return a.Resource < b.Resource
+	})
+	return list
+}
+
+// WriteOwnershipTable writes the ownership as aligned columns, one line per name and owner
+func WriteOwnershipTable(w io.Writer, list []Ownership) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tOWNER\tRESOURCE\tTYPES")
+	for _, o := range list {
+		resource := o.Resource
+		if resource == "" {
+			resource = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", o.DNSName, o.Owner, resource, strings.Join(o.RecordTypes, ","))
+	}
+	return tw.Flush()
+}
+
+// WriteOwnershipJSON writes the ownership as indented JSON
+func WriteOwnershipJSON(w io.Writer, list []Ownership) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -21,6 +21,33 @@ type Registry interface {
 	GetDomainFilter() endpoint.DomainFilterInterface
 }
 
+// Migrator is implemented by registries which convert their ownership records between formats
+type Migrator interface {
+	Migrate(ctx context.Context) error
+}
+
+// OwnershipAuditor is implemented by registries which detect ownership records without their
+// record, and records without their ownership record, when reading the records
+type OwnershipAuditor interface {
+	// OwnershipAudit returns the findings of the last read of the records
+	OwnershipAudit() OwnershipAudit
+	// DeleteOrphanedOwnership deletes the removable orphaned ownership records whose record
+	// the policy allows to delete and returns the number of records deleted
+	DeleteOrphanedOwnership(ctx context.Context, policy plan.Policy) (int, error)
+}
+
+// OwnershipAudit holds the inconsistencies between records and their ownership records,
+// usually left behind by manual edits
+type OwnershipAudit struct {
+	// Orphaned are the ownership records whose record does not exist, labeled with their owner
+	Orphaned []*endpoint.Endpoint
+	// Removable are the orphaned ownership records owned by this instance whose name can only
+	// be read one way, the ones deleted by DeleteOrphanedOwnership
+	Removable []*endpoint.Endpoint
+	// Dangling are the records which were owned but lost their ownership record
+	Dangling []*endpoint.Endpoint
+}
+
 //TODO(ideahitme): consider moving this to Plan
 func filterOwnedRecords(ownerID string, eps []*endpoint.Endpoint) []*endpoint.Endpoint {
 	filtered := []*endpoint.Endpoint{}
@@ -33,3 +60,22 @@ func filterOwnedRecords(ownerID string, eps []*endpoint.Endpoint) []*endpoint.En
 	}
 	return filtered
 }
+
+// filterOwnedReplacements filters the replaced records and their replacements as pairs, by the
+// owner of the replaced record, so that the pairs stay together even if their owners differ
+func filterOwnedReplacements(ownerID string, replaced, replacements []*endpoint.Endpoint) ([]*endpoint.Endpoint, []*endpoint.Endpoint) {
+	filteredOld, filteredNew := []*endpoint.Endpoint{}, []*endpoint.Endpoint{}
+	for i, ep := range replaced {
+		if i >= len(replacements) {
+			log.Warnf("Skipping replaced endpoint %v without replacement", ep)
+			continue
+		}
+		if endpointOwner, ok := ep.Labels[endpoint.OwnerLabelKey]; !ok || endpointOwner != ownerID {
+			log.Debugf(`Skipping replacement of endpoint %v because owner id does not match, found: "%s", required: "%s"`, ep, endpointOwner, ownerID)
+			continue
+		}
+		filteredOld = append(filteredOld, ep)
+		filteredNew = append(filteredNew, replacements[i])
+	}
+	return filteredOld, filteredNew
+}
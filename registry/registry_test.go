@@ -0,0 +1,39 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+func owned(name, recordType, owner string) *endpoint.Endpoint {
+	ep := endpoint.NewEndpoint(name, recordType, "192.0.2.1")
+	if owner != "" {
+		ep.Labels[endpoint.OwnerLabelKey] = owner
+	}
+	return ep
+}
+
+func TestFilterOwnedReplacements(t *testing.T) {
+	old := []*endpoint.Endpoint{
+		owned("foo.example.com", endpoint.RecordTypeA, "other"),
+		owned("bar.example.com", endpoint.RecordTypeA, "owner"),
+		owned("baz.example.com", endpoint.RecordTypeA, ""),
+		owned("qux.example.com", endpoint.RecordTypeA, "owner"),
+	}
+	replacements := []*endpoint.Endpoint{
+		owned("foo.example.com", endpoint.RecordTypeCNAME, "owner"),
+		owned("bar.example.com", endpoint.RecordTypeCNAME, "other"),
+		owned("baz.example.com", endpoint.RecordTypeCNAME, "owner"),
+		owned("qux.example.com", endpoint.RecordTypeCNAME, "owner"),
+	}
+
+	filteredOld, filteredNew := filterOwnedReplacements("owner", old, replacements)
+	if expected := []*endpoint.Endpoint{old[1], old[3]}; !reflect.DeepEqual(filteredOld, expected) {
+		t.Errorf("expected replaced %v, got %v", expected, filteredOld)
+	}
+	if expected := []*endpoint.Endpoint{replacements[1], replacements[3]}; !reflect.DeepEqual(filteredNew, expected) {
+		t.Errorf("expected replacements %v, got %v", expected, filteredNew)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/toppr-systems/dops/endpoint"
 	"github.com/toppr-systems/dops/plan"
 	"github.com/toppr-systems/dops/provider"
+	"github.com/toppr-systems/dops/source"
 )
 
 // TXTRegistry implements registry interface with ownership implemented via associated TXT records
@@ -29,19 +30,67 @@ type TXTRegistry struct {
 	// registry TXT records corresponding to wildcard records will be invalid (and rejected by most providers), due to
 	// having a '*' appear (not as the first character) - see https://tools.ietf.org/html/rfc1034#section-4.3.3
 	wildcardReplacement string
+
+	// format is the naming format of the ownership records written by the registry
+	format string
+	// legacyOwned and typedOwned hold the records whose ownership record exists in the
+	// legacy and in the typed naming format, keyed by ownershipKey
+	legacyOwned map[string]bool
+	typedOwned  map[string]bool
+
+	// cipher encrypts the labels of the ownership records written by the registry, if set
+	cipher *endpoint.LabelsCipher
+	// txtTargets holds the values of the ownership records as found by Records, keyed by txtKey.
+	// Encrypted values can not be regenerated from the labels once the key is rotated, so
+	// ownership records are deleted and updated with the value they were found with.
+	txtTargets map[string]string
+
+	// audit holds the findings of the last read of the records
+	audit OwnershipAudit
+	// wasOwned holds the owners of the records owned when the records were last read, keyed
+	// by ownershipKey, to detect records losing their ownership record between two reads
+	wasOwned map[string]string
+	// orphanedRecords holds the records the removable orphaned ownership records belonged to,
+	// keyed by txtKey, to apply the policy to their deletion
+	orphanedRecords map[string]*endpoint.Endpoint
 }
 
+// Naming formats of the ownership TXT records
+const (
+	// TXTFormatLegacy gives the ownership records of A and CNAME records the same name
+	TXTFormatLegacy = "legacy"
+	// TXTFormatTyped puts the record type into the names of the ownership records of A, CNAME, NS and PTR
+	// records, in front of the prefix or behind the suffix, e.g. a-txt-host and cname-txt-host
+	TXTFormatTyped = "typed"
+	// TXTFormatMigrate writes typed ownership records and converts the legacy ones owned by this instance,
+	// the legacy records are deleted once all of them have a typed counterpart
+	TXTFormatMigrate = "migrate"
+)
+
 // NewTXTRegistry returns new TXTRegistry object
-func NewTXTRegistry(provider provider.Provider, txtPrefix, txtSuffix, ownerID string, cacheInterval time.Duration, txtWildcardReplacement string) (*TXTRegistry, error) {
+func NewTXTRegistry(provider provider.Provider, txtPrefix, txtSuffix, ownerID string, cacheInterval time.Duration, txtWildcardReplacement, txtFormat string, labelsCipher *endpoint.LabelsCipher) (*TXTRegistry, error) {
 	if ownerID == "" {
 		return nil, errors.New("owner id cannot be empty")
 	}
 
+	switch txtFormat {
+	case "":
+		txtFormat = TXTFormatLegacy
+	case TXTFormatLegacy, TXTFormatTyped, TXTFormatMigrate:
+	default:
+		return nil, fmt.Errorf("unknown txt format %q", txtFormat)
+	}
+
 	if len(txtPrefix) > 0 && len(txtSuffix) > 0 {
 		return nil, errors.New("txt-prefix and txt-suffix are mutual exclusive")
 	}
 
 	mapper := newaffixNameMapper(txtPrefix, txtSuffix, txtWildcardReplacement)
+	if txtFormat != TXTFormatLegacy {
+		if err := mapper.checkTyped(); err != nil {
+			return nil, err
+		}
+	}
 
 	return &TXTRegistry{
 		provider:            provider,
@@ -49,6 +98,12 @@ func NewTXTRegistry(provider provider.Provider, txtPrefix, txtSuffix, ownerID st
 		mapper:              mapper,
 		cacheInterval:       cacheInterval,
 		wildcardReplacement: txtWildcardReplacement,
+		format:              txtFormat,
+		legacyOwned:         map[string]bool{},
+		typedOwned:          map[string]bool{},
+		cipher:              labelsCipher,
+		txtTargets:          map[string]string{},
+		wasOwned:            map[string]string{},
 	}, nil
 }
 
@@ -75,6 +130,20 @@ func (im *TXTRegistry) Records(ctx context.Context) ([]*endpoint.Endpoint, error
 	endpoints := []*endpoint.Endpoint{}
 
 	labelMap := map[string]endpoint.Labels{}
+	typedLabelMap := map[string]endpoint.Labels{}
+	txtTargets := map[string]string{}
+
+	// keys holds the keys of every reading of the name, key the one read
+	type ownershipRecord struct {
+		record    *endpoint.Endpoint
+		labels    endpoint.Labels
+		names     []endpointName
+		keys      []string
+		key       string
+		typedName endpointName
+		typedKey  string
+	}
+	ownershipRecords := []ownershipRecord{}
 
 	for _, record := range records {
 		if record.RecordType != endpoint.RecordTypeTXT {
@@ -82,7 +151,7 @@ func (im *TXTRegistry) Records(ctx context.Context) ([]*endpoint.Endpoint, error
 			continue
 		}
 		// We simply assume that TXT records for the registry will always have only one target.
-		labels, err := endpoint.NewLabelsFromString(record.Targets[0])
+		labels, err := im.parseLabels(record.Targets[0])
 		if err == endpoint.ErrInvalidOrigin {
 			//if no origin is found or it is invalid
 			//case when value of txt record cannot be identified
@@ -90,30 +159,111 @@ func (im *TXTRegistry) Records(ctx context.Context) ([]*endpoint.Endpoint, error
 			endpoints = append(endpoints, record)
 			continue
 		}
+		if err == endpoint.ErrEncryptedLabels || err == endpoint.ErrUndecryptableLabels {
+			// the owned record appears unowned and is left alone
+			log.Warnf("Ignoring ownership record %s: %v", record.DNSName, err)
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
-		key := fmt.Sprintf("%s::%s", im.mapper.toEndpointName(record.DNSName), record.SetIdentifier)
-		labelMap[key] = labels
+		txtTargets[txtKey(record)] = record.Targets[0]
+		o := ownershipRecord{record: record, labels: labels, names: im.mapper.toEndpointNames(record.DNSName)}
+		if dnsName, recordType := im.mapper.toTypedEndpointName(record.DNSName); recordType != "" && im.format != TXTFormatLegacy {
+			o.typedName = endpointName{dnsName: dnsName, recordType: recordType}
+			o.typedKey = labelKey(dnsName, record.SetIdentifier, recordType)
+			typedLabelMap[o.typedKey] = labels
+		}
+		ownershipRecords = append(ownershipRecords, o)
 	}
 
+	// a name like mx-host is either the ownership record of the MX record host or of the A record
+	// mx-host, it is read as the former only if an MX record host exists
+	existing := map[string]bool{}
+	for _, ep := range endpoints {
+		dnsName := im.ownershipName(ep)
+		existing[labelKey(dnsName, ep.SetIdentifier, txtRecordType(ep.RecordType))] = true
+		if isUntypedRecordType(ep.RecordType) {
+			existing[labelKey(dnsName, ep.SetIdentifier, ep.RecordType)] = true
+		}
+	}
+	for i := range ownershipRecords {
+		o := &ownershipRecords[i]
+		for _, n := range o.names {
+			key := labelKey(n.dnsName, o.record.SetIdentifier, n.recordType)
+			o.keys = append(o.keys, key)
+			if o.key == "" || existing[key] {
+				o.key = key
+			}
+		}
+		if o.key != "" {
+			labelMap[o.key] = o.labels
+		}
+	}
+
+	legacyOwned, typedOwned := map[string]bool{}, map[string]bool{}
+	audit := OwnershipAudit{}
+	wasOwned := map[string]string{}
+	orphanedRecords := map[string]*endpoint.Endpoint{}
 	for _, ep := range endpoints {
 		if ep.Labels == nil {
 			ep.Labels = endpoint.NewLabels()
 		}
-		dnsNameSplit := strings.Split(ep.DNSName, ".")
-		// If specified, replace a leading asterisk in the generated txt record name with some other string
-		if im.wildcardReplacement != "" && dnsNameSplit[0] == "*" {
-			dnsNameSplit[0] = im.wildcardReplacement
+		dnsName := im.ownershipName(ep)
+		key := labelKey(dnsName, ep.SetIdentifier, txtRecordType(ep.RecordType))
+		labels, ok := labelMap[key]
+		if ok && isUntypedRecordType(ep.RecordType) {
+			legacyOwned[ownershipKey(ep)] = true
+		}
+		// typed ownership records take precedence over legacy ones
+		typedKey := labelKey(dnsName, ep.SetIdentifier, ep.RecordType)
+		if typedLabels, typed := typedLabelMap[typedKey]; typed && isUntypedRecordType(ep.RecordType) {
+			labels, ok = typedLabels, true
+			typedOwned[ownershipKey(ep)] = true
 		}
-		dnsName := strings.Join(dnsNameSplit, ".")
-		key := fmt.Sprintf("%s::%s", dnsName, ep.SetIdentifier)
-		if labels, ok := labelMap[key]; ok {
+		if ok {
 			for k, v := range labels {
 				ep.Labels[k] = v
 			}
 		}
+
+		if owner := ep.Labels[endpoint.OwnerLabelKey]; owner != "" {
+			wasOwned[ownershipKey(ep)] = owner
+		} else if owner, dangling := im.wasOwned[ownershipKey(ep)]; dangling {
+			// keep reporting the record until its ownership record is restored
+			wasOwned[ownershipKey(ep)] = owner
+			log.Warnf("Record %s %s owned by %s has lost its ownership record", ep.DNSName, ep.RecordType, owner)
+			audit.Dangling = append(audit.Dangling, ep)
+		}
+	}
+	for _, o := range ownershipRecords {
+		keys := o.keys
+		if o.typedKey != "" {
+			keys = append(keys, o.typedKey)
+		}
+		if len(keys) == 0 || anyExisting(existing, keys) {
+			// matched by any reading, or not named by this registry
+			continue
+		}
+		orphan := o.record.DeepCopy()
+		orphan.Labels = o.labels
+		log.Warnf("Ownership record %s owned by %s has no record", orphan.DNSName, orphan.Labels[endpoint.OwnerLabelKey])
+		audit.Orphaned = append(audit.Orphaned, orphan)
+		// a name read in more than one way may still belong to a record created later under another reading
+		if len(keys) == 1 && orphan.Labels[endpoint.OwnerLabelKey] == im.ownerID {
+			audit.Removable = append(audit.Removable, orphan)
+			name := o.typedName
+			if len(o.names) == 1 {
+				name = o.names[0]
+			}
+			record := endpoint.NewEndpoint(name.dnsName, name.recordType).WithSetIdentifier(orphan.SetIdentifier)
+			record.Labels = orphan.Labels
+			orphanedRecords[txtKey(orphan)] = record
+		}
 	}
+	im.legacyOwned, im.typedOwned = legacyOwned, typedOwned
+	im.txtTargets = txtTargets
+	im.audit, im.wasOwned, im.orphanedRecords = audit, wasOwned, orphanedRecords
 
 	// Update the cache.
 	if im.cacheInterval > 0 {
@@ -133,14 +283,21 @@ func (im *TXTRegistry) ApplyChanges(ctx context.Context, changes *plan.Changes)
 		UpdateOld: filterOwnedRecords(im.ownerID, changes.UpdateOld),
 		Delete:    filterOwnedRecords(im.ownerID, changes.Delete),
 	}
+	filteredChanges.ReplaceOld, filteredChanges.ReplaceNew = filterOwnedReplacements(im.ownerID, changes.ReplaceOld, changes.ReplaceNew)
+	return im.applyOwnedChanges(ctx, filteredChanges)
+}
+
+// applyOwnedChanges applies the changes of owned records along with the changes of their ownership records
+func (im *TXTRegistry) applyOwnedChanges(ctx context.Context, filteredChanges *plan.Changes) error {
 	for _, r := range filteredChanges.Create {
 		if r.Labels == nil {
 			r.Labels = make(map[string]string)
 		}
 		r.Labels[endpoint.OwnerLabelKey] = im.ownerID
-		txt := endpoint.NewEndpoint(im.mapper.toTXTName(r.DNSName), endpoint.RecordTypeTXT, r.Labels.Serialize(true)).WithSetIdentifier(r.SetIdentifier)
-		txt.ProviderSpecific = r.ProviderSpecific
+		txt := im.newTXTRecord(r)
 		filteredChanges.Create = append(filteredChanges.Create, txt)
+		im.setOwnership(r, im.format != TXTFormatLegacy)
+		im.txtTargets[txtKey(txt)] = txt.Targets[0]
 
 		if im.cacheInterval > 0 {
 			im.addToCache(r)
@@ -148,42 +305,74 @@ func (im *TXTRegistry) ApplyChanges(ctx context.Context, changes *plan.Changes)
 	}
 
 	for _, r := range filteredChanges.Delete {
-		txt := endpoint.NewEndpoint(im.mapper.toTXTName(r.DNSName), endpoint.RecordTypeTXT, r.Labels.Serialize(true)).WithSetIdentifier(r.SetIdentifier)
-		txt.ProviderSpecific = r.ProviderSpecific
-
 		// when we delete TXT records for which value has changed (due to new label) this would still work because
 		// !!! TXT record value is uniquely generated from the Labels of the endpoint. Hence old TXT record can be uniquely reconstructed
-		filteredChanges.Delete = append(filteredChanges.Delete, txt)
+		for _, txt := range im.currentOwnershipRecords(r) {
+			filteredChanges.Delete = append(filteredChanges.Delete, txt)
+			delete(im.txtTargets, txtKey(txt))
+		}
+		im.clearOwnership(r)
 
 		if im.cacheInterval > 0 {
 			im.removeFromCache(r)
 		}
 	}
 
-	// make sure TXT records are consistently updated as well
+	// make sure TXT records are consistently updated as well, in the format they exist in
 	for _, r := range filteredChanges.UpdateOld {
-		txt := endpoint.NewEndpoint(im.mapper.toTXTName(r.DNSName), endpoint.RecordTypeTXT, r.Labels.Serialize(true)).WithSetIdentifier(r.SetIdentifier)
-		txt.ProviderSpecific = r.ProviderSpecific
 		// when we updateOld TXT records for which value has changed (due to new label) this would still work because
 		// !!! TXT record value is uniquely generated from the Labels of the endpoint. Hence old TXT record can be uniquely reconstructed
-		filteredChanges.UpdateOld = append(filteredChanges.UpdateOld, txt)
+		filteredChanges.UpdateOld = append(filteredChanges.UpdateOld, im.currentOwnershipRecords(r)...)
 		// remove old version of record from cache
 		if im.cacheInterval > 0 {
 			im.removeFromCache(r)
 		}
 	}
 
-	// make sure TXT records are consistently updated as well
+	// make sure TXT records are consistently updated as well, in the format they exist in
 	for _, r := range filteredChanges.UpdateNew {
-		txt := endpoint.NewEndpoint(im.mapper.toTXTName(r.DNSName), endpoint.RecordTypeTXT, r.Labels.Serialize(true)).WithSetIdentifier(r.SetIdentifier)
-		txt.ProviderSpecific = r.ProviderSpecific
-		filteredChanges.UpdateNew = append(filteredChanges.UpdateNew, txt)
+		for _, txt := range im.ownershipRecords(r) {
+			filteredChanges.UpdateNew = append(filteredChanges.UpdateNew, txt)
+			im.txtTargets[txtKey(txt)] = txt.Targets[0]
+		}
 		// add new version of record to cache
 		if im.cacheInterval > 0 {
 			im.addToCache(r)
 		}
 	}
 
+	// a record changing type keeps its ownership record if the TXT name does not encode the
+	// type, e.g. between A and CNAME in the legacy format, otherwise the ownership record is
+	// replaced as well
+	for i, r := range filteredChanges.ReplaceNew {
+		old := filteredChanges.ReplaceOld[i]
+		newTXT := im.newTXTRecord(r)
+		kept := false
+		for _, oldTXT := range im.currentOwnershipRecords(old) {
+			delete(im.txtTargets, txtKey(oldTXT))
+			if oldTXT.DNSName != newTXT.DNSName {
+				filteredChanges.Delete = append(filteredChanges.Delete, oldTXT)
+				continue
+			}
+			kept = true
+			if !oldTXT.Targets.Same(newTXT.Targets) {
+				filteredChanges.UpdateOld = append(filteredChanges.UpdateOld, oldTXT)
+				filteredChanges.UpdateNew = append(filteredChanges.UpdateNew, newTXT)
+			}
+		}
+		if !kept {
+			filteredChanges.Create = append(filteredChanges.Create, newTXT)
+		}
+		im.txtTargets[txtKey(newTXT)] = newTXT.Targets[0]
+		im.clearOwnership(old)
+		im.setOwnership(r, im.format != TXTFormatLegacy)
+
+		if im.cacheInterval > 0 {
+			im.removeFromCache(old)
+			im.addToCache(r)
+		}
+	}
+
 	// when caching is enabled, disable the provider from using the cache
 	if im.cacheInterval > 0 {
 		ctx = context.WithValue(ctx, provider.RecordsContextKey, nil)
@@ -191,6 +380,291 @@ func (im *TXTRegistry) ApplyChanges(ctx context.Context, changes *plan.Changes)
 	return im.provider.ApplyChanges(ctx, filteredChanges)
 }
 
+// generateTXTRecord returns the ownership TXT record for the given endpoint in the legacy naming format
+func (im *TXTRegistry) generateTXTRecord(r *endpoint.Endpoint) *endpoint.Endpoint {
+	txt := endpoint.NewEndpoint(im.mapper.toTXTName(r.DNSName, r.RecordType), endpoint.RecordTypeTXT, im.serializeLabels(r.Labels)).WithSetIdentifier(r.SetIdentifier)
+	txt.ProviderSpecific = txtProviderSpecific(r.ProviderSpecific)
+	return txt
+}
+
+// generateTypedTXTRecord returns the ownership TXT record for the given endpoint in the typed naming format
+func (im *TXTRegistry) generateTypedTXTRecord(r *endpoint.Endpoint) *endpoint.Endpoint {
+	txt := endpoint.NewEndpoint(im.mapper.toTypedTXTName(r.DNSName, r.RecordType), endpoint.RecordTypeTXT, im.serializeLabels(r.Labels)).WithSetIdentifier(r.SetIdentifier)
+	txt.ProviderSpecific = txtProviderSpecific(r.ProviderSpecific)
+	return txt
+}
+
+// txtProviderSpecific returns the provider specific properties of a record which apply to its
+// ownership record as well, e.g. a Cloudflare load balancer would be created for the ownership record
+func txtProviderSpecific(properties endpoint.ProviderSpecific) endpoint.ProviderSpecific {
+	if properties == nil {
+		return nil
+	}
+	filtered := endpoint.ProviderSpecific{}
+	for _, p := range properties {
+		switch p.Name {
+		case source.CloudflareProxiedKey, source.CloudflareLoadBalancerKey, source.CloudflareLoadBalancerSteeringKey:
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	return filtered
+}
+
+// newTXTRecord returns the ownership TXT record for the given endpoint in the configured naming format
+func (im *TXTRegistry) newTXTRecord(r *endpoint.Endpoint) *endpoint.Endpoint {
+	if im.format == TXTFormatLegacy || !isUntypedRecordType(r.RecordType) {
+		return im.generateTXTRecord(r)
+	}
+	return im.generateTypedTXTRecord(r)
+}
+
+// ownershipRecords returns the ownership TXT records of the given endpoint in the formats they
+// were found in by Records, or in the configured format if none was found
+func (im *TXTRegistry) ownershipRecords(r *endpoint.Endpoint) []*endpoint.Endpoint {
+	if !isUntypedRecordType(r.RecordType) {
+		// both formats name these the same
+		return []*endpoint.Endpoint{im.generateTXTRecord(r)}
+	}
+	key := ownershipKey(r)
+	records := []*endpoint.Endpoint{}
+	if im.typedOwned[key] {
+		records = append(records, im.generateTypedTXTRecord(r))
+	}
+	if im.legacyOwned[key] {
+		records = append(records, im.generateTXTRecord(r))
+	}
+	if len(records) == 0 {
+		records = append(records, im.newTXTRecord(r))
+	}
+	return records
+}
+
+// currentOwnershipRecords returns the ownership records of the given endpoint like ownershipRecords,
+// with the values they were found with by Records
+func (im *TXTRegistry) currentOwnershipRecords(r *endpoint.Endpoint) []*endpoint.Endpoint {
+	return im.withCurrentTargets(im.ownershipRecords(r))
+}
+
+func (im *TXTRegistry) withCurrentTargets(records []*endpoint.Endpoint) []*endpoint.Endpoint {
+	for _, txt := range records {
+		if target, ok := im.txtTargets[txtKey(txt)]; ok {
+			txt.Targets = endpoint.Targets{target}
+		}
+	}
+	return records
+}
+
+// serializeLabels returns the value of an ownership record, encrypted if the registry has a cipher
+func (im *TXTRegistry) serializeLabels(labels endpoint.Labels) string {
+	if im.cipher != nil {
+		return im.cipher.Serialize(labels, true)
+	}
+	return labels.Serialize(true)
+}
+
+// parseLabels reads the value of an ownership record, encrypted or not
+func (im *TXTRegistry) parseLabels(text string) (endpoint.Labels, error) {
+	if im.cipher != nil {
+		return im.cipher.NewLabelsFromString(text)
+	}
+	return endpoint.NewLabelsFromString(text)
+}
+
+func (im *TXTRegistry) setOwnership(r *endpoint.Endpoint, typed bool) {
+	im.clearOwnership(r)
+	if typed {
+		im.typedOwned[ownershipKey(r)] = true
+	} else {
+		im.legacyOwned[ownershipKey(r)] = true
+	}
+}
+
+func (im *TXTRegistry) clearOwnership(r *endpoint.Endpoint) {
+	delete(im.typedOwned, ownershipKey(r))
+	delete(im.legacyOwned, ownershipKey(r))
+}
+
+// ownershipName returns the name of the endpoint as it appears in the name of its ownership record
+func (im *TXTRegistry) ownershipName(ep *endpoint.Endpoint) string {
+	dnsNameSplit := strings.Split(ep.DNSName, ".")
+	// If specified, replace a leading asterisk in the generated txt record name with some other string
+	if im.wildcardReplacement != "" && dnsNameSplit[0] == "*" {
+		dnsNameSplit[0] = im.wildcardReplacement
+	}
+	return strings.Join(dnsNameSplit, ".")
+}
+
+// Migrate converts the legacy ownership records owned by this instance to the typed naming format
+// when the registry runs in the migrate format. The legacy records are deleted only once every
+// owned record has a typed ownership record, so that instances reading only the legacy format
+// keep working until the conversion is complete.
+func (im *TXTRegistry) Migrate(ctx context.Context) error {
+	if im.format != TXTFormatMigrate {
+		return nil
+	}
+	records, err := im.Records(ctx)
+	if err != nil {
+		return err
+	}
+
+	changes := &plan.Changes{}
+	converted := []*endpoint.Endpoint{}
+	for _, r := range records {
+		if r.Labels[endpoint.OwnerLabelKey] != im.ownerID || !isUntypedRecordType(r.RecordType) {
+			continue
+		}
+		key := ownershipKey(r)
+		switch {
+		case im.legacyOwned[key] && !im.typedOwned[key]:
+			changes.Create = append(changes.Create, im.generateTypedTXTRecord(r))
+			converted = append(converted, r)
+		case im.legacyOwned[key] && im.typedOwned[key]:
+			changes.Delete = append(changes.Delete, im.withCurrentTargets([]*endpoint.Endpoint{im.generateTXTRecord(r)})...)
+		}
+	}
+	if len(changes.Create) > 0 {
+		// garbage collect the legacy records in a later run, once all are converted
+		changes.Delete = nil
+	}
+	if !changes.HasChanges() {
+		return nil
+	}
+
+	if im.cacheInterval > 0 {
+		ctx = context.WithValue(ctx, provider.RecordsContextKey, nil)
+	}
+	if err := im.provider.ApplyChanges(ctx, changes); err != nil {
+		return err
+	}
+	for _, r := range converted {
+		im.typedOwned[ownershipKey(r)] = true
+	}
+	if len(changes.Delete) > 0 {
+		for k := range im.legacyOwned {
+			delete(im.legacyOwned, k)
+		}
+	}
+	log.Infof("Migrated ownership records to the typed format: %d created, %d legacy records deleted", len(changes.Create), len(changes.Delete))
+	return nil
+}
+
+// TransferOwnership rewrites the ownership records of the records owned by from, within the
+// domain filter, to name to as the owner and returns the transferred records. The records are
+// updated like ApplyChanges updates owned records, so the ownership records get the naming
+// format and encryption of the registry, and records whose update the policy does not allow
+// are not transferred. Names to already owns are not transferred, the transfer is refused as
+// a whole instead.
+func (im *TXTRegistry) TransferOwnership(ctx context.Context, from, to string, domainFilter endpoint.DomainFilter, policy plan.Policy) ([]*endpoint.Endpoint, error) {
+	if from == "" || to == "" {
+		return nil, errors.New("owner ids cannot be empty")
+	}
+	if from == to {
+		return nil, fmt.Errorf("records are already owned by %s", to)
+	}
+	// read the ownership records as they are now
+	im.recordsCache = nil
+	records, err := im.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := map[string]bool{}
+	for _, r := range records {
+		if r.Labels[endpoint.OwnerLabelKey] == to {
+			owned[strings.ToLower(r.DNSName)] = true
+		}
+	}
+
+	changes := &plan.Changes{}
+	collisions := []string{}
+	for _, r := range records {
+		if r.Labels[endpoint.OwnerLabelKey] != from || !domainFilter.Match(r.DNSName) {
+			continue
+		}
+		if name := strings.ToLower(r.DNSName); owned[name] {
+			if !containsString(collisions, name) {
+				collisions = append(collisions, name)
+			}
+			continue
+		}
+		updated := r.DeepCopy()
+		updated.Labels[endpoint.OwnerLabelKey] = to
+		changes.UpdateOld = append(changes.UpdateOld, r)
+		changes.UpdateNew = append(changes.UpdateNew, updated)
+	}
+	if len(collisions) > 0 {
+		return nil, fmt.Errorf("%s already owns %s", to, strings.Join(collisions, ", "))
+	}
+	changes = plan.ApplyPolicy(policy, changes)
+	if len(changes.UpdateNew) == 0 {
+		return changes.UpdateNew, nil
+	}
+
+	transferred := changes.UpdateNew
+	if err := im.applyOwnedChanges(ctx, changes); err != nil {
+		return nil, err
+	}
+	im.recordsCache = nil
+	return transferred, nil
+}
+
+// OwnershipAudit returns the ownership records without their record and the records which lost
+// their ownership record as found by the last read of the records. A record losing its ownership
+// record looks like any other record not owned by dops, so it is only detected if it was owned
+// when the records were read before by this process.
+func (im *TXTRegistry) OwnershipAudit() OwnershipAudit {
+	return im.audit
+}
+
+// DeleteOrphanedOwnership deletes the removable orphaned ownership records found when the
+// records were last read, if the policy allows to delete the records they belonged to
+func (im *TXTRegistry) DeleteOrphanedOwnership(ctx context.Context, policy plan.Policy) (int, error) {
+	records := []*endpoint.Endpoint{}
+	orphans := map[*endpoint.Endpoint]*endpoint.Endpoint{}
+	for _, orphan := range im.audit.Removable {
+		record := im.orphanedRecords[txtKey(orphan)]
+		records = append(records, record)
+		orphans[record] = orphan
+	}
+	changes := &plan.Changes{}
+	for _, record := range plan.ApplyPolicy(policy, &plan.Changes{Delete: records}).Delete {
+		orphan := orphans[record]
+		txt := endpoint.NewEndpoint(orphan.DNSName, orphan.RecordType, orphan.Targets...).WithSetIdentifier(orphan.SetIdentifier)
+		txt.ProviderSpecific = orphan.ProviderSpecific
+		changes.Delete = append(changes.Delete, txt)
+	}
+	if len(changes.Delete) == 0 {
+		return 0, nil
+	}
+
+	if im.cacheInterval > 0 {
+		ctx = context.WithValue(ctx, provider.RecordsContextKey, nil)
+	}
+	if err := im.provider.ApplyChanges(ctx, changes); err != nil {
+		return 0, err
+	}
+	deleted := map[string]bool{}
+	for _, txt := range changes.Delete {
+		log.Infof("Deleted orphaned ownership record %s", txt.DNSName)
+		delete(im.txtTargets, txtKey(txt))
+		deleted[txtKey(txt)] = true
+	}
+	remaining, removable := []*endpoint.Endpoint{}, []*endpoint.Endpoint{}
+	for _, orphan := range im.audit.Orphaned {
+		if !deleted[txtKey(orphan)] {
+			remaining = append(remaining, orphan)
+		}
+	}
+	for _, orphan := range im.audit.Removable {
+		if !deleted[txtKey(orphan)] {
+			removable = append(removable, orphan)
+		}
+	}
+	im.audit.Orphaned, im.audit.Removable = remaining, removable
+	return len(changes.Delete), nil
+}
+
 // PropertyValuesEqual compares two attribute values for equality
 func (im *TXTRegistry) PropertyValuesEqual(name string, previous string, current string) bool {
 	return im.provider.PropertyValuesEqual(name, previous, current)
@@ -211,8 +685,83 @@ func (im *TXTRegistry) AdjustEndpoints(endpoints []*endpoint.Endpoint) []*endpoi
 */
 
 type nameMapper interface {
-	toEndpointName(string) string
-	toTXTName(string) string
+	// toEndpointNames returns the readings of a TXT name in the legacy naming format
+	toEndpointNames(string) []endpointName
+	// toTypedEndpointName returns the endpoint name and record type if the TXT name is a typed
+	// ownership name of a record type the legacy format does not encode
+	toTypedEndpointName(string) (string, string)
+	toTXTName(string, string) string
+	// toTypedTXTName returns the TXT name in the typed naming format
+	toTypedTXTName(string, string) string
+}
+
+// endpointName is a reading of an ownership TXT name, the record type is empty for the
+// record types sharing the TXT name without a type prefix
+type endpointName struct {
+	dnsName    string
+	recordType string
+}
+
+// ownershipKey identifies the ownership of a record in the registry
+func ownershipKey(ep *endpoint.Endpoint) string {
+	return labelKey(strings.ToLower(ep.DNSName), ep.SetIdentifier, ep.RecordType)
+}
+
+// txtKey identifies an ownership record
+func txtKey(txt *endpoint.Endpoint) string {
+	return labelKey(strings.ToLower(txt.DNSName), txt.SetIdentifier, "")
+}
+
+func anyExisting(existing map[string]bool, keys []string) bool {
+	for _, key := range keys {
+		if existing[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// labelKey identifies the ownership labels of a record
+func labelKey(dnsName, setIdentifier, recordType string) string {
+	return fmt.Sprintf("%s::%s::%s", dnsName, setIdentifier, recordType)
+}
+
+// typedRecordTypes are the record types whose ownership TXT name is prefixed with the record type
+var typedRecordTypes = []string{
+	endpoint.RecordTypeAAAA,
+	endpoint.RecordTypeMX,
+	endpoint.RecordTypeSRV,
+	endpoint.RecordTypeCAA,
+	endpoint.RecordTypeTXT,
+}
+
+// txtRecordType returns the record type that is encoded in the ownership TXT name
+// of a record with the given type. A and CNAME records share the plain TXT name,
+// other types would collide with it and therefore get a type prefix.
+func txtRecordType(recordType string) string {
+	for _, t := range typedRecordTypes {
+		if recordType == t {
+			return recordType
+		}
+	}
+	return ""
+}
+
+// untypedRecordTypes are the record types whose ownership TXT name encodes the type only in the typed naming format
+var untypedRecordTypes = []string{
+	endpoint.RecordTypeA,
+	endpoint.RecordTypeCNAME,
+	endpoint.RecordTypeNS,
+	endpoint.RecordTypePTR,
+}
+
+func isUntypedRecordType(recordType string) bool {
+	for _, t := range untypedRecordTypes {
+		if recordType == t {
+			return true
+		}
+	}
+	return false
 }
 
 type affixNameMapper struct {
@@ -227,22 +776,103 @@ func newaffixNameMapper(prefix string, suffix string, wildcardReplacement string
 	return affixNameMapper{prefix: strings.ToLower(prefix), suffix: strings.ToLower(suffix), wildcardReplacement: strings.ToLower(wildcardReplacement)}
 }
 
-func (pr affixNameMapper) toEndpointName(txtDNSName string) string {
+// toEndpointNames returns the plain reading of the TXT name and, if its first label starts with
+// a record type prefix such as mx-, the reading without the prefix, as the name mx-host may
+// belong to the A record mx-host as well as to the MX record host
+func (pr affixNameMapper) toEndpointNames(txtDNSName string) []endpointName {
+	name, ok := pr.dropAffix(txtDNSName)
+	if !ok {
+		return nil
+	}
+	names := []endpointName{{dnsName: name}}
+	if stripped, recordType := dropRecordTypePrefix(name); recordType != "" {
+		names = append(names, endpointName{dnsName: stripped, recordType: recordType})
+	}
+	return names
+}
+
+func (pr affixNameMapper) toTypedEndpointName(txtDNSName string) (string, string) {
+	if pr.prefix == "" && pr.suffix == "" {
+		return "", ""
+	}
+	lowerDNSName := strings.ToLower(txtDNSName)
+	for _, recordType := range untypedRecordTypes {
+		marker := strings.ToLower(recordType)
+		var name string
+		if pr.prefix != "" {
+			if !strings.HasPrefix(lowerDNSName, marker+"-"+pr.prefix) {
+				continue
+			}
+			name = strings.TrimPrefix(lowerDNSName, marker+"-")
+		} else {
+			DNSName := strings.SplitN(lowerDNSName, ".", 2)
+			if !strings.HasSuffix(DNSName[0], pr.suffix+"-"+marker) {
+				continue
+			}
+			DNSName[0] = strings.TrimSuffix(DNSName[0], "-"+marker)
+			name = strings.Join(DNSName, ".")
+		}
+		if endpointName, ok := pr.dropAffix(name); ok {
+			return endpointName, recordType
+		}
+	}
+	return "", ""
+}
+
+// checkTyped returns an error unless the typed names can be told apart from the legacy ones.
+// Every legacy name starts with the prefix, or has a first label ending with the suffix, and
+// typed names put the record type in front of the prefix, or behind the suffix, so the affix
+// must not look like it has the record type already.
+func (pr affixNameMapper) checkTyped() error {
+	if pr.prefix == "" && pr.suffix == "" {
+		return errors.New("the typed txt format requires txt-prefix or txt-suffix")
+	}
+	for _, recordType := range untypedRecordTypes {
+		marker := strings.ToLower(recordType)
+		if pr.prefix != "" && strings.HasPrefix(marker+"-"+pr.prefix, pr.prefix) {
+			return fmt.Errorf("txt-prefix %q can not be told apart from the typed names of %s records", pr.prefix, recordType)
+		}
+		if pr.suffix != "" && strings.HasSuffix(pr.suffix+"-"+marker, pr.suffix) {
+			return fmt.Errorf("txt-suffix %q can not be told apart from the typed names of %s records", pr.suffix, recordType)
+		}
+	}
+	return nil
+}
+
+// dropAffix returns the TXT name without the prefix or suffix, and false if it has none
+func (pr affixNameMapper) dropAffix(txtDNSName string) (string, bool) {
 	lowerDNSName := strings.ToLower(txtDNSName)
 	if strings.HasPrefix(lowerDNSName, pr.prefix) && len(pr.suffix) == 0 {
-		return strings.TrimPrefix(lowerDNSName, pr.prefix)
+		return strings.TrimPrefix(lowerDNSName, pr.prefix), true
 	}
 
 	if len(pr.suffix) > 0 {
 		DNSName := strings.SplitN(lowerDNSName, ".", 2)
-		if strings.HasSuffix(DNSName[0], pr.suffix) {
-			return strings.TrimSuffix(DNSName[0], pr.suffix) + "." + DNSName[1]
+		if strings.HasSuffix(DNSName[0], pr.suffix) && len(DNSName) == 2 {
+			return strings.TrimSuffix(DNSName[0], pr.suffix) + "." + DNSName[1], true
 		}
 	}
-	return ""
+	return "", false
+}
+
+func (pr affixNameMapper) toTXTName(endpointDNSName, recordType string) string {
+	return pr.txtName(endpointDNSName, txtRecordType(recordType))
+}
+
+// toTypedTXTName returns the TXT name with the record type in front of the prefix, e.g. a-txt-host,
+// or behind the suffix, e.g. host-txt-a
+func (pr affixNameMapper) toTypedTXTName(endpointDNSName, recordType string) string {
+	marker := strings.ToLower(recordType)
+	if pr.prefix != "" {
+		return marker + "-" + pr.txtName(endpointDNSName, "")
+	}
+	DNSName := strings.SplitN(pr.txtName(endpointDNSName, ""), ".", 2)
+	DNSName[0] += "-" + marker
+	return strings.Join(DNSName, ".")
 }
 
-func (pr affixNameMapper) toTXTName(endpointDNSName string) string {
+// txtName returns the TXT name of the endpoint, prefixed with the record type if it is not empty
+func (pr affixNameMapper) txtName(endpointDNSName, recordType string) string {
 	DNSName := strings.SplitN(endpointDNSName, ".", 2)
 
 	// If specified, replace a leading asterisk in the generated txt record name with some other string
@@ -250,12 +880,26 @@ func (pr affixNameMapper) toTXTName(endpointDNSName string) string {
 		DNSName[0] = pr.wildcardReplacement
 	}
 
+	if recordType != "" {
+		DNSName[0] = strings.ToLower(recordType) + "-" + DNSName[0]
+	}
+
 	if len(DNSName) < 2 {
 		return pr.prefix + DNSName[0] + pr.suffix
 	}
 	return pr.prefix + DNSName[0] + pr.suffix + "." + DNSName[1]
 }
 
+// dropRecordTypePrefix splits a record type prefix, as added by toTXTName, off the given name
+func dropRecordTypePrefix(dnsName string) (string, string) {
+	for _, t := range typedRecordTypes {
+		if p := strings.ToLower(t) + "-"; strings.HasPrefix(dnsName, p) {
+			return strings.TrimPrefix(dnsName, p), t
+		}
+	}
+	return dnsName, ""
+}
+
 func (im *TXTRegistry) addToCache(ep *endpoint.Endpoint) {
 	if im.recordsCache != nil {
 		im.recordsCache = append(im.recordsCache, ep)
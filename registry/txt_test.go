@@ -0,0 +1,309 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/toppr-systems/dops/endpoint"
+	"github.com/toppr-systems/dops/plan"
+	"github.com/toppr-systems/dops/provider/inmemory"
+)
+
+const testOwnership = `"origin=dops,dops/owner=owner"`
+
+// newTestProvider returns an in-memory provider holding the given records, without labels
+// as a real provider would return them
+func newTestProvider(t *testing.T, records ...*endpoint.Endpoint) *inmemory.InMemoryProvider {
+	t.Helper()
+	p := inmemory.NewInMemoryProvider(inmemory.InMemoryInitZones([]string{"example.com"}))
+	if err := p.ApplyChanges(context.Background(), &plan.Changes{Create: records}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func newTestRegistry(t *testing.T, p *inmemory.InMemoryProvider, format string) *TXTRegistry {
+	t.Helper()
+	r, err := NewTXTRegistry(p, "txt-", "", "owner", time.Duration(0), "", format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func owners(t *testing.T, r *TXTRegistry) map[string]string {
+	t.Helper()
+	records, err := r.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := map[string]string{}
+	for _, ep := range records {
+		if ep.RecordType != endpoint.RecordTypeTXT {
+			owners[ep.DNSName+" "+ep.RecordType] = ep.Labels[endpoint.OwnerLabelKey]
+		}
+	}
+	return owners
+}
+
+func TestAffixNameMapperToEndpointNames(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, suffix, txtName string
+		expected                []endpointName
+	}{
+		{"txt-", "", "txt-foo.example.com", []endpointName{{dnsName: "foo.example.com"}}},
+		{"txt-", "", "txt-mx-1.example.com", []endpointName{{dnsName: "mx-1.example.com"}, {dnsName: "1.example.com", recordType: endpoint.RecordTypeMX}}},
+		{"txt-", "", "txt-aaaa-foo.example.com", []endpointName{{dnsName: "aaaa-foo.example.com"}, {dnsName: "foo.example.com", recordType: endpoint.RecordTypeAAAA}}},
+		{"", "-txt", "txt-foo-txt.example.com", []endpointName{{dnsName: "txt-foo.example.com"}, {dnsName: "foo.example.com", recordType: endpoint.RecordTypeTXT}}},
+		{"txt-", "", "foo.example.com", nil},
+	} {
+		mapper := newaffixNameMapper(tc.prefix, tc.suffix, "")
+		if names := mapper.toEndpointNames(tc.txtName); !reflect.DeepEqual(names, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.txtName, tc.expected, names)
+		}
+	}
+}
+
+func TestTXTRegistryRecordTypePrefixedNames(t *testing.T) {
+	p := newTestProvider(t,
+		// an A record whose name starts with a record type prefix, owned
+		endpoint.NewEndpoint("mx-1.example.com", endpoint.RecordTypeA, "192.0.2.1"),
+		endpoint.NewEndpoint("txt-mx-1.example.com", endpoint.RecordTypeTXT, testOwnership),
+		// an owned AAAA record next to an unowned A record named like its ownership record
+		endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeAAAA, "2001:db8::1"),
+		endpoint.NewEndpoint("txt-aaaa-foo.example.com", endpoint.RecordTypeTXT, testOwnership),
+		endpoint.NewEndpoint("aaaa-foo.example.com", endpoint.RecordTypeA, "192.0.2.2"),
+	)
+	r := newTestRegistry(t, p, TXTFormatLegacy)
+
+	expected := map[string]string{
+		"mx-1.example.com A":     "owner",
+		"foo.example.com AAAA":   "owner",
+		"aaaa-foo.example.com A": "",
+	}
+	if got := owners(t, r); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected owners %v, got %v", expected, got)
+	}
+	if orphaned := r.OwnershipAudit().Orphaned; len(orphaned) != 0 {
+		t.Errorf("expected no orphaned ownership records, got %v", orphaned)
+	}
+}
+
+func TestAffixNameMapperTypedNames(t *testing.T) {
+	for _, mapper := range []affixNameMapper{newaffixNameMapper("txt-", "", ""), newaffixNameMapper("", "-txt", "")} {
+		for _, recordType := range untypedRecordTypes {
+			typed := mapper.toTypedTXTName("foo.example.com", recordType)
+			if name, got := mapper.toTypedEndpointName(typed); name != "foo.example.com" || got != recordType {
+				t.Errorf("%s: expected foo.example.com %s, got %s %s", typed, recordType, name, got)
+			}
+			if names := mapper.toEndpointNames(typed); names != nil {
+				t.Errorf("%s: expected no legacy reading, got %v", typed, names)
+			}
+		}
+		// legacy ownership records of hosts named like typed ownership records
+		for _, host := range []string{"a-foo.example.com", "cname-x.example.com", "ns-1.example.com", "ptr-y.example.com"} {
+			legacy := mapper.toTXTName(host, endpoint.RecordTypeA)
+			if name, recordType := mapper.toTypedEndpointName(legacy); recordType != "" {
+				t.Errorf("%s: expected no typed reading, got %s %s", legacy, name, recordType)
+			}
+		}
+	}
+}
+
+func TestAffixNameMapperCheckTyped(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, suffix string
+		valid          bool
+	}{
+		{"", "", false},
+		{"txt-", "", true},
+		{"dops.", "", true},
+		{"", "-txt", true},
+		{"a-", "", false},
+		{"cname-cname-", "", false},
+		{"", "-a", false},
+	} {
+		if err := newaffixNameMapper(tc.prefix, tc.suffix, "").checkTyped(); (err == nil) != tc.valid {
+			t.Errorf("prefix %q suffix %q: expected valid %v, got %v", tc.prefix, tc.suffix, tc.valid, err)
+		}
+	}
+}
+
+func TestTXTRegistryTypedNameCollisions(t *testing.T) {
+	for _, format := range []string{TXTFormatLegacy, TXTFormatTyped, TXTFormatMigrate} {
+		p := newTestProvider(t,
+			// legacy ownership records of hosts named like typed ownership records
+			endpoint.NewEndpoint("a-foo.example.com", endpoint.RecordTypeA, "192.0.2.1"),
+			endpoint.NewEndpoint("txt-a-foo.example.com", endpoint.RecordTypeTXT, testOwnership),
+			endpoint.NewEndpoint("ptr-y.example.com", endpoint.RecordTypeCNAME, "y.example.net"),
+			endpoint.NewEndpoint("txt-ptr-y.example.com", endpoint.RecordTypeTXT, testOwnership),
+			// unowned records at the names without the type
+			endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.2"),
+			endpoint.NewEndpoint("y.example.com", endpoint.RecordTypePTR, "host.example.com"),
+			// a typed ownership record
+			endpoint.NewEndpoint("bar.example.com", endpoint.RecordTypeCNAME, "bar.example.net"),
+			endpoint.NewEndpoint("cname-txt-bar.example.com", endpoint.RecordTypeTXT, testOwnership),
+		)
+		r := newTestRegistry(t, p, format)
+
+		expected := map[string]string{
+			"a-foo.example.com A":     "owner",
+			"ptr-y.example.com CNAME": "owner",
+			"foo.example.com A":       "",
+			"y.example.com PTR":       "",
+			"bar.example.com CNAME":   "owner",
+		}
+		if format == TXTFormatLegacy {
+			expected["bar.example.com CNAME"] = ""
+		}
+		if got := owners(t, r); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected owners %v, got %v", format, expected, got)
+		}
+	}
+}
+
+func TestTXTRegistryDeleteOrphanedOwnership(t *testing.T) {
+	p := newTestProvider(t,
+		// the ownership record of a live A record named like a typed ownership record
+		endpoint.NewEndpoint("mx-1.example.com", endpoint.RecordTypeA, "192.0.2.1"),
+		endpoint.NewEndpoint("txt-mx-1.example.com", endpoint.RecordTypeTXT, testOwnership),
+		// orphans named ambiguously, unambiguously, and owned by another instance
+		endpoint.NewEndpoint("txt-mx-2.example.com", endpoint.RecordTypeTXT, testOwnership),
+		endpoint.NewEndpoint("txt-gone.example.com", endpoint.RecordTypeTXT, testOwnership),
+		endpoint.NewEndpoint("txt-protected.example.com", endpoint.RecordTypeTXT, testOwnership),
+		endpoint.NewEndpoint("txt-other.example.com", endpoint.RecordTypeTXT, `"origin=dops,dops/owner=other"`),
+	)
+	r := newTestRegistry(t, p, TXTFormatLegacy)
+	owners(t, r)
+
+	audit := r.OwnershipAudit()
+	if len(audit.Orphaned) != 4 {
+		t.Errorf("expected 4 orphaned ownership records, got %v", audit.Orphaned)
+	}
+	removable := map[string]bool{}
+	for _, ep := range audit.Removable {
+		removable[ep.DNSName] = true
+	}
+	if len(audit.Removable) != 2 || !removable["txt-gone.example.com"] || !removable["txt-protected.example.com"] {
+		t.Errorf("expected txt-gone.example.com and txt-protected.example.com to be removable, got %v", audit.Removable)
+	}
+
+	// the policy applies to the name of the record, not to the name of its ownership record
+	pattern, err := plan.NewNamePattern("protected.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := r.DeleteOrphanedOwnership(context.Background(), &plan.ProtectedNamesPolicy{Patterns: []plan.NamePattern{pattern}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 deleted ownership record, got %d", deleted)
+	}
+	if orphaned := r.OwnershipAudit().Orphaned; len(orphaned) != 3 {
+		t.Errorf("expected 3 remaining orphaned ownership records, got %v", orphaned)
+	}
+
+	records, err := p.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, ep := range records {
+		names[ep.DNSName] = true
+	}
+	for _, name := range []string{"txt-mx-1.example.com", "txt-mx-2.example.com", "txt-protected.example.com", "txt-other.example.com"} {
+		if !names[name] {
+			t.Errorf("expected %s to be kept", name)
+		}
+	}
+	if names["txt-gone.example.com"] {
+		t.Error("expected txt-gone.example.com to be deleted")
+	}
+}
+
+func TestTXTRegistryTransferOwnership(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t,
+		endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.1"),
+		endpoint.NewEndpoint("txt-foo.example.com", endpoint.RecordTypeTXT, `"origin=dops,dops/owner=old"`),
+		endpoint.NewEndpoint("bar.example.com", endpoint.RecordTypeMX, "10 mail.example.com"),
+		endpoint.NewEndpoint("txt-mx-bar.example.com", endpoint.RecordTypeTXT, `"origin=dops,dops/owner=old"`),
+		endpoint.NewEndpoint("baz.example.com", endpoint.RecordTypeA, "192.0.2.2"),
+		endpoint.NewEndpoint("txt-baz.example.com", endpoint.RecordTypeTXT, `"origin=dops,dops/owner=new"`),
+	)
+	r := newTestRegistry(t, p, TXTFormatLegacy)
+
+	transferred, err := r.TransferOwnership(ctx, "old", "new", endpoint.NewDomainFilter(nil), &plan.SyncPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transferred) != 2 {
+		t.Errorf("expected 2 transferred records, got %v", transferred)
+	}
+	expected := map[string]string{
+		"foo.example.com A":  "new",
+		"bar.example.com MX": "new",
+		"baz.example.com A":  "new",
+	}
+	if got := owners(t, r); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected owners %v, got %v", expected, got)
+	}
+}
+
+func TestTXTRegistryTransferOwnershipCollision(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t,
+		endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.1"),
+		endpoint.NewEndpoint("txt-foo.example.com", endpoint.RecordTypeTXT, `"origin=dops,dops/owner=old"`),
+		endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeAAAA, "2001:db8::1"),
+		endpoint.NewEndpoint("txt-aaaa-foo.example.com", endpoint.RecordTypeTXT, `"origin=dops,dops/owner=new"`),
+		endpoint.NewEndpoint("bar.example.com", endpoint.RecordTypeA, "192.0.2.2"),
+		endpoint.NewEndpoint("txt-bar.example.com", endpoint.RecordTypeTXT, `"origin=dops,dops/owner=old"`),
+	)
+	r := newTestRegistry(t, p, TXTFormatLegacy)
+
+	if _, err := r.TransferOwnership(ctx, "old", "new", endpoint.NewDomainFilter(nil), &plan.SyncPolicy{}); err == nil {
+		t.Fatal("expected the transfer to be refused")
+	}
+	expected := map[string]string{
+		"foo.example.com A":    "old",
+		"foo.example.com AAAA": "new",
+		"bar.example.com A":    "old",
+	}
+	if got := owners(t, r); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected owners %v, got %v", expected, got)
+	}
+}
+
+func TestTXTRegistryTransferOwnershipPolicy(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t,
+		endpoint.NewEndpoint("foo.example.com", endpoint.RecordTypeA, "192.0.2.1"),
+		endpoint.NewEndpoint("txt-foo.example.com", endpoint.RecordTypeTXT, `"origin=dops,dops/owner=old"`),
+		endpoint.NewEndpoint("bar.example.com", endpoint.RecordTypeA, "192.0.2.2"),
+		endpoint.NewEndpoint("txt-bar.example.com", endpoint.RecordTypeTXT, `"origin=dops,dops/owner=old"`),
+	)
+	r := newTestRegistry(t, p, TXTFormatLegacy)
+	pattern, err := plan.NewNamePattern("foo.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transferred, err := r.TransferOwnership(ctx, "old", "new", endpoint.NewDomainFilter(nil), &plan.ProtectedNamesPolicy{Patterns: []plan.NamePattern{pattern}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transferred) != 1 || transferred[0].DNSName != "bar.example.com" {
+		t.Errorf("expected only bar.example.com to be transferred, got %v", transferred)
+	}
+	expected := map[string]string{
+		"foo.example.com A": "old",
+		"bar.example.com A": "new",
+	}
+	if got := owners(t, r); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected owners %v, got %v", expected, got)
+	}
+}
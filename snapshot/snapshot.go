@@ -0,0 +1,127 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/toppr-systems/dops/endpoint"
+)
+
+// version is the version of the snapshot format
+const version = 1
+
+// defaultTTL is the TTL of the records without a configured TTL in BIND zone files
+const defaultTTL = 300
+
+// Snapshot holds the records of the zones of a provider at a point in time
+type Snapshot struct {
+	Version   int                  `json:"version"`
+	CreatedAt time.Time            `json:"createdAt"`
+	Records   []*endpoint.Endpoint `json:"records"`
+}
+
+// New returns a snapshot of the records, sorted by name, type and set identifier
+func New(records []*endpoint.Endpoint) *Snapshot {
+	sorted := append([]*endpoint.Endpoint{}, records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.DNSName != b.DNSName {
+			return a.DNSName < b.DNSName
+		}
+		if a.RecordType != b.RecordType {
+			return a.RecordType < b.RecordType
+		}
+		return a.SetIdentifier < b.SetIdentifier
+	})
+	return &Snapshot{
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+		Records:   sorted,
+	}
+}
+
+// Read reads a snapshot written by WriteJSON
+func Read(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %v", path, err)
+	}
+	if s.Version != version {
+		return nil, fmt.Errorf("unsupported version %d of snapshot %s", s.Version, path)
+	}
+	return s, nil
+}
+
+// WriteJSON writes the snapshot as indented JSON
+func (s *Snapshot) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WriteBIND writes the records as a BIND zone file with absolute names. Set identifiers and
+// provider specific properties have no equivalent and are written as comments.
+func (s *Snapshot) WriteBIND(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "; dops snapshot created at %s\n", s.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(b, "$TTL %d\n", defaultTTL)
+	for _, ep := range s.Records {
+		comments := []string{}
+		if ep.SetIdentifier != "" {
+			comments = append(comments, "set-identifier="+ep.SetIdentifier)
+		}
+		for _, p := range ep.ProviderSpecific {
+			comments = append(comments, p.Name+"="+p.Value)
+		}
+		if len(comments) > 0 {
+			fmt.Fprintf(b, "; %s\n", strings.Join(comments, " "))
+		}
+
+		ttl := ""
+		if ep.RecordTTL.IsConfigured() {
+			ttl = fmt.Sprintf("%d ", ep.RecordTTL)
+		}
+		for _, target := range ep.Targets {
+			fmt.Fprintf(b, "%s %sIN %s %s\n", absolute(ep.DNSName), ttl, ep.RecordType, bindData(ep.RecordType, target))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// bindData returns the record data in zone file syntax, with absolute host names
+func bindData(recordType, target string) string {
+	switch recordType {
+	case endpoint.RecordTypeCNAME, endpoint.RecordTypeNS:
+		return absolute(target)
+	case endpoint.RecordTypeMX, endpoint.RecordTypeSRV:
+		// the host name is the last field, e.g. "10 mail.example.com"
+		fields := strings.Fields(target)
+		if len(fields) > 0 {
+			fields[len(fields)-1] = absolute(fields[len(fields)-1])
+		}
+		return strings.Join(fields, " ")
+	case endpoint.RecordTypeTXT:
+		if strings.HasPrefix(target, "\"") {
+			return target
+		}
+		return fmt.Sprintf("%q", target)
+	}
+	return target
+}
+
+func absolute(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
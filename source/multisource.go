@@ -21,12 +21,20 @@ func (ms *multiSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, err
 		if err != nil {
 			return nil, err
 		}
-		if len(ms.defaultTargets) > 0 {
-			for i := range endpoints {
-				endpoints[i].Targets = ms.defaultTargets
+		if len(ms.defaultTargets) == 0 {
+			result = append(result, splitAddressTargets(endpoints)...)
+			continue
+		}
+		// default targets may mix addresses and hostnames, so the record type
+		// is derived from the targets rather than kept from the source
+		for _, ep := range endpoints {
+			for _, e := range endpointsForHostname(ep.DNSName, ms.defaultTargets, ep.RecordTTL, ep.ProviderSpecific, ep.SetIdentifier) {
+				for k, v := range ep.Labels {
+					e.Labels[k] = v
+				}
+				result = append(result, e)
 			}
 		}
-		result = append(result, endpoints...)
 	}
 
 	return result, nil
@@ -0,0 +1,33 @@
+package source
+
+import (
+	"context"
+
+	"github.com/toppr-systems/dops/endpoint"
+	"github.com/toppr-systems/dops/snapshot"
+)
+
+// snapshotSource is a Source that returns the records of a snapshot written by dops export
+type snapshotSource struct {
+	path string
+}
+
+// NewSnapshotSource creates a new snapshotSource reading the given snapshot file.
+func NewSnapshotSource(path string) Source {
+	return &snapshotSource{path: path}
+}
+
+func (s *snapshotSource) AddEventHandler(ctx context.Context, handler func()) {
+}
+
+// Endpoints returns the records of the snapshot, without their ownership labels
+func (s *snapshotSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	snap, err := snapshot.Read(s.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, ep := range snap.Records {
+		delete(ep.Labels, endpoint.OwnerLabelKey)
+	}
+	return snap.Records, nil
+}
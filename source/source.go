@@ -16,6 +16,11 @@ import (
 const (
 	// The annotation to determine whether traffic will go through Cloudflare
 	CloudflareProxiedKey = "dops/cloudflare-proxied"
+	// The annotation to serve the hostname with a Cloudflare load balancer, its value lists
+	// the pools of the load balancer and their origins, e.g. "primary=10.0.0.1,10.0.0.2@0.5;backup=10.1.0.1"
+	CloudflareLoadBalancerKey = "dops/cloudflare-load-balancer"
+	// The annotation to set the steering policy of a Cloudflare load balancer, e.g. "random"
+	CloudflareLoadBalancerSteeringKey = "dops/cloudflare-load-balancer-steering"
 
 	SetIdentifierKey = "dops/set-identifier"
 )
@@ -58,10 +63,14 @@ func parseTemplate(fqdnTemplate string) (tmpl *template.Template, err error) {
 }
 
 // suitableType returns the DNS resource record type suitable for the target.
-// In this case type A for IPs and type CNAME for everything else.
+// In this case type A for IPv4 addresses, type AAAA for IPv6 addresses and
+// type CNAME for everything else.
 func suitableType(target string) string {
-	if net.ParseIP(target) != nil {
-		return endpoint.RecordTypeA
+	if ip := net.ParseIP(target); ip != nil {
+		if ip.To4() != nil {
+			return endpoint.RecordTypeA
+		}
+		return endpoint.RecordTypeAAAA
 	}
 	return endpoint.RecordTypeCNAME
 }
@@ -71,12 +80,15 @@ func endpointsForHostname(hostname string, targets endpoint.Targets, ttl endpoin
 	var endpoints []*endpoint.Endpoint
 
 	var aTargets endpoint.Targets
+	var aaaaTargets endpoint.Targets
 	var cnameTargets endpoint.Targets
 
 	for _, t := range targets {
 		switch suitableType(t) {
 		case endpoint.RecordTypeA:
 			aTargets = append(aTargets, t)
+		case endpoint.RecordTypeAAAA:
+			aaaaTargets = append(aaaaTargets, t)
 		default:
 			cnameTargets = append(cnameTargets, t)
 		}
@@ -95,6 +107,19 @@ func endpointsForHostname(hostname string, targets endpoint.Targets, ttl endpoin
 		endpoints = append(endpoints, epA)
 	}
 
+	if len(aaaaTargets) > 0 {
+		epAAAA := &endpoint.Endpoint{
+			DNSName:          strings.TrimSuffix(hostname, "."),
+			Targets:          aaaaTargets,
+			RecordTTL:        ttl,
+			RecordType:       endpoint.RecordTypeAAAA,
+			Labels:           endpoint.NewLabels(),
+			ProviderSpecific: providerSpecific,
+			SetIdentifier:    setIdentifier,
+		}
+		endpoints = append(endpoints, epAAAA)
+	}
+
 	if len(cnameTargets) > 0 {
 		epCNAME := &endpoint.Endpoint{
 			DNSName:          strings.TrimSuffix(hostname, "."),
@@ -111,6 +136,25 @@ func endpointsForHostname(hostname string, targets endpoint.Targets, ttl endpoin
 	return endpoints
 }
 
+// splitAddressTargets splits address endpoints whose targets mix IPv4 and IPv6
+// addresses into an A and an AAAA endpoint. Other endpoints are returned as is.
+func splitAddressTargets(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	result := make([]*endpoint.Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if ep.RecordType != endpoint.RecordTypeA && ep.RecordType != endpoint.RecordTypeAAAA {
+			result = append(result, ep)
+			continue
+		}
+		for _, e := range endpointsForHostname(ep.DNSName, ep.Targets, ep.RecordTTL, ep.ProviderSpecific, ep.SetIdentifier) {
+			for k, v := range ep.Labels {
+				e.Labels[k] = v
+			}
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
 type eventHandlerFunc func()
 
 func (fn eventHandlerFunc) OnAdd(obj interface{})               { fn() }